@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyAuthSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sign := hmacBase64("secret", ts+"\nalice")
+
+	cases := []struct {
+		name                string
+		user, ts, signature string
+		want                bool
+	}{
+		{"有效签名", "alice", ts, sign, true},
+		{"用户名不一致", "mallory", ts, sign, false},
+		{"密钥不一致", "alice", ts, hmacBase64("other", ts+"\nalice"), false},
+		{"签名过期", "alice", strconv.FormatInt(now.Add(-authMaxSkew-time.Second).Unix(), 10), sign, false},
+		{"时间无效", "alice", "abc", sign, false},
+	}
+	for _, tc := range cases {
+		if got := verifyAuthSignature("secret", tc.user, tc.ts, tc.signature, now); got != tc.want {
+			t.Errorf("%s: 校验结果 %v，期望 %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestConfirmSuspensionUsesAuthenticatedUser 挂起确认按认证用户校验权限，忽略请求体中的操作人
+func TestConfirmSuspensionUsesAuthenticatedUser(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	if err := initEnforcer(); err != nil {
+		t.Fatal(err)
+	}
+	version := VersionModel{ID: "V-AUTH", Name: "版本", VersionOwner: "alice", Status: "running", ItemIDs: `[]`}
+	if _, err := s.CreateVersionWithItems(&version, nil); err != nil {
+		t.Fatal(err)
+	}
+	suspension := SuspensionModel{VersionID: "V-AUTH", ItemID: "I1", Stage: StageBTETest, Reason: "测试不通过"}
+	if err := s.db.Create(&suspension).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware(AuthConfig{Secret: "secret"}))
+	r.POST("/api/versions/:versionId/suspensions/:id/confirm", confirmSuspension)
+	confirm := func(user string, signed bool) int {
+		path := "/api/versions/V-AUTH/suspensions/" + strconv.FormatUint(uint64(suspension.ID), 10) + "/confirm"
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"operator":"alice"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(headerAuthUser, user)
+			req.Header.Set(headerAuthTimestamp, ts)
+			if signed {
+				req.Header.Set(headerAuthSignature, hmacBase64("secret", ts+"\n"+user))
+			}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := confirm("", false); code != http.StatusUnauthorized {
+		t.Fatalf("未认证时返回 %d，期望 401", code)
+	}
+	if code := confirm("alice", false); code != http.StatusUnauthorized {
+		t.Fatalf("未签名时返回 %d，期望 401", code)
+	}
+	if code := confirm("mallory", true); code != http.StatusForbidden {
+		t.Fatalf("非版本负责人确认时返回 %d，期望 403", code)
+	}
+	if code := confirm("alice", true); code != http.StatusOK {
+		t.Fatalf("版本负责人确认时返回 %d，期望 200", code)
+	}
+	confirmed, err := s.GetSuspensionByID(suspension.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !confirmed.Confirmed || confirmed.ConfirmedBy != "alice" {
+		t.Fatalf("挂起记录未按认证用户确认: %+v", confirmed)
+	}
+}
+
+// TestCloseItemRecordsAuthenticatedUser 关闭条目记录认证用户，忽略请求体中的操作人
+func TestCloseItemRecordsAuthenticatedUser(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	item := ItemModel{ID: "I-AUTH", Name: "条目", Type: "需求", Status: ItemStatusRegistered}
+	if err := s.CreateItem(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware(AuthConfig{}))
+	r.POST("/api/items/:itemId/close", closeItemHandler)
+	closeItem := func(user string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/items/I-AUTH/close",
+			strings.NewReader(`{"revision":0,"reason":"重复登记","operator":"mallory"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set(headerAuthUser, user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := closeItem(""); code != http.StatusUnauthorized {
+		t.Fatalf("未认证时返回 %d，期望 401", code)
+	}
+	if code := closeItem("alice"); code != http.StatusOK {
+		t.Fatalf("关闭条目返回 %d，期望 200", code)
+	}
+	transitions, err := s.GetItemTransitions("I-AUTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) == 0 || transitions[len(transitions)-1].Operator != "alice" {
+		t.Fatalf("状态变更未记录认证用户: %+v", transitions)
+	}
+}
+
+// TestTransitionItemRecordsAuthenticatedUser 手工变更状态记录认证用户，忽略请求体中的操作人
+func TestTransitionItemRecordsAuthenticatedUser(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	item := ItemModel{ID: "I-AUTH", Name: "条目", Type: "需求", Status: ItemStatusRegistered}
+	if err := s.CreateItem(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware(AuthConfig{}))
+	r.POST("/api/items/:itemId/transition", transitionItemHandler)
+	transition := func(user string) int {
+		body := `{"status":"` + ItemStatusTestComplete + `","operator":"mallory"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/items/I-AUTH/transition", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set(headerAuthUser, user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := transition(""); code != http.StatusUnauthorized {
+		t.Fatalf("未认证时返回 %d，期望 401", code)
+	}
+	if code := transition("alice"); code != http.StatusOK {
+		t.Fatalf("变更状态返回 %d，期望 200", code)
+	}
+	transitions, err := s.GetItemTransitions("I-AUTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transitions) == 0 || transitions[len(transitions)-1].Operator != "alice" {
+		t.Fatalf("状态变更未记录认证用户: %+v", transitions)
+	}
+}
+
+// TestUpdateNotifyPreferenceOnlySelf 只能修改自己的通知偏好
+func TestUpdateNotifyPreferenceOnlySelf(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware(AuthConfig{}))
+	r.PUT("/api/users/:user/notify-preference", updateNotifyPreference)
+	update := func(user string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/users/alice/notify-preference",
+			strings.NewReader(`{"channels":"email","email":"mallory@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set(headerAuthUser, user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := update(""); code != http.StatusUnauthorized {
+		t.Fatalf("未认证时返回 %d，期望 401", code)
+	}
+	if code := update("mallory"); code != http.StatusForbidden {
+		t.Fatalf("修改他人偏好返回 %d，期望 403", code)
+	}
+	if _, err := s.GetNotifyPreference("alice"); err == nil {
+		t.Fatal("他人的修改不应保存")
+	}
+	if code := update("alice"); code != http.StatusOK {
+		t.Fatalf("修改自己的偏好返回 %d，期望 200", code)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 子命令
+//   serve   只提供 HTTP API，可以和 Worker 分别扩容
+//   worker  只运行 Temporal Worker，启动前检查运行中的流程都由当前代码版本启动，见 workflow_version.go
+//   migrate 按版本迁移表结构、初始化默认流程配置
+//   seed    初始化演示数据
+// serve、worker 和 seed 启动时检查表结构版本，未迁移时拒绝启动
+//   all     迁移、演示数据、API 和 Worker 在一个进程中，未指定子命令时使用
+// 收到 SIGINT/SIGTERM 后 API 处理完进行中的请求、Worker 等待进行中的 Activity 后退出
+// ============================================================
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, cfg *Config) error
+}
+
+var commands = []command{
+	{"serve", "提供 HTTP API", runServe},
+	{"worker", "运行 Temporal Worker", runWorker},
+	{"migrate", "按版本迁移表结构并初始化默认流程配置", runMigrate},
+	{"seed", "初始化演示数据", runSeed},
+	{"all", "迁移、演示数据、API 和 Worker 在一个进程中运行（默认）", runAll},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "用法: upgrade-backend [子命令] [-config 配置文件] [参数]")
+	fmt.Fprintln(os.Stderr, "子命令:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+}
+
+func runMigrate(ctx context.Context, cfg *Config) error {
+	if err := initStore(cfg.Database); err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Migrate()
+}
+
+func runSeed(ctx context.Context, cfg *Config) error {
+	if err := initStore(cfg.Database); err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.CheckSchema(); err != nil {
+		return err
+	}
+	return store.InitDemoData()
+}
+
+func runServe(ctx context.Context, cfg *Config) error {
+	c, err := setup(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	defer c.Close()
+	return serveHTTP(ctx, cfg)
+}
+
+func runWorker(ctx context.Context, cfg *Config) error {
+	c, err := setup(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	defer c.Close()
+
+	w, err := startWorker(ctx, c, cfg)
+	if err != nil {
+		return err
+	}
+	logger.Info("Worker 已启动", zap.String("taskQueue", TaskQueue))
+
+	<-ctx.Done()
+	logger.Info("Worker 停止中，等待进行中的 Activity")
+	w.Stop()
+	logger.Info("Worker 已停止")
+	return nil
+}
+
+func runAll(ctx context.Context, cfg *Config) error {
+	if err := initStore(cfg.Database); err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.Migrate(); err != nil {
+		return err
+	}
+	if err := store.InitDemoData(); err != nil {
+		return err
+	}
+	c, err := setup(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	w, err := startWorker(ctx, c, cfg)
+	if err != nil {
+		return err
+	}
+	// API 先停止接收请求，再停止 Worker
+	defer func() {
+		w.Stop()
+		logger.Info("Worker 已停止")
+	}()
+	return serveHTTP(ctx, cfg)
+}
+
+// startWorker 检查运行中的流程都由当前代码版本启动后启动 Worker
+func startWorker(ctx context.Context, c client.Client, cfg *Config) (worker.Worker, error) {
+	if cfg.Temporal.SkipDrainCheck {
+		logger.Warn("已跳过运行中流程的代码版本检查", zap.Int("codeVersion", workflowCodeVersion))
+	} else if err := checkWorkflowsDrained(ctx, c, cfg.Temporal.Namespace); err != nil {
+		return nil, err
+	}
+
+	w := newWorker(c, cfg.Server.ShutdownTimeout)
+	if err := w.Start(); err != nil {
+		return nil, fmt.Errorf("Worker 启动失败: %w", err)
+	}
+	return w, nil
+}
+
+// initStore 连接数据库并设置全局存储
+func initStore(cfg DatabaseConfig) error {
+	s, err := openStore(cfg)
+	if err != nil {
+		return fmt.Errorf("数据库连接失败: %w", err)
+	}
+	store = s
+	return nil
+}
+
+// setup 初始化 API 和 Worker 共用的依赖，返回 Temporal 客户端
+func setup(cfg *Config) (client.Client, error) {
+	if store == nil {
+		if err := initStore(cfg.Database); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.CheckSchema(); err != nil {
+		return nil, err
+	}
+
+	// 编号格式
+	if err := initIDFormats(cfg.IDFormats); err != nil {
+		return nil, err
+	}
+
+	// 初始化阶段权限
+	if err := initEnforcer(); err != nil {
+		return nil, fmt.Errorf("权限模型加载失败: %w", err)
+	}
+
+	// 初始化通知渠道
+	initNotifiers(cfg.Notify.notifyConfig())
+
+	// 连接 Temporal Server
+	c, err := client.Dial(client.Options{
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("无法连接 Temporal: %w", err)
+	}
+	temporalClient = c
+	return c, nil
+}
+
+// serveHTTP 提供 HTTP API，ctx 结束后等待进行中的请求完成
+func serveHTTP(ctx context.Context, cfg *Config) error {
+	srv := &http.Server{Addr: cfg.Server.Addr, Handler: newRouter(cfg)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	logger.Info("========================================")
+	logger.Info("BSS3.0 升级流程管理系统")
+	logger.Info("========================================")
+	logger.Info("后端服务启动", zap.String("addr", cfg.Server.Addr))
+	if cfg.Auth.Secret == "" {
+		logger.Warn("未配置 auth.secret，直接信任 X-Auth-User 请求头，后端只能由网关访问")
+	}
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("服务启动失败: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("服务停止中，等待进行中的请求", zap.Duration("timeout", cfg.Server.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("服务停止失败: %w", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("服务已停止")
+	return nil
+}
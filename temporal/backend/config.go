@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================================================
+// 服务配置
+// 优先级从低到高：默认值 < YAML 配置文件 < 环境变量 < 命令行参数
+// 配置文件由 -config 或 UPGRADE_CONFIG 指定，未指定时不读取
+// 密码等敏感配置可以通过 xxx_file 从文件读取（如容器挂载的 secret），
+// 不要写在配置文件中；启动时打印的配置会隐藏敏感值
+// ============================================================
+
+// Config 服务配置
+type Config struct {
+	Server    ServerConfig      `yaml:"server"`
+	Database  DatabaseConfig    `yaml:"database"`
+	Temporal  TemporalConfig    `yaml:"temporal"`
+	Notify    NotifySettings    `yaml:"notify"`
+	Auth      AuthConfig        `yaml:"auth"`
+	IDFormats map[string]string `yaml:"id_formats"` // 序列 -> 编号格式，见 sequence.go
+}
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`             // 监听地址
+	FrontendPath    string        `yaml:"frontend_path"`    // 前端页面
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // 停止时等待进行中的请求和 Activity 的时间
+}
+
+// DatabaseConfig 数据库连接配置
+// Driver 为 mysql、postgres 或 sqlite；sqlite 只使用 Path，其余驱动不使用 Path
+type DatabaseConfig struct {
+	Driver       string `yaml:"driver"`
+	Path         string `yaml:"path"` // SQLite 数据库文件
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"` // 为 0 时使用驱动的默认端口
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	Name         string `yaml:"name"`
+	Params       string `yaml:"params"` // 连接参数，为空时使用驱动的默认参数
+}
+
+// TemporalConfig Temporal 连接配置
+type TemporalConfig struct {
+	HostPort       string `yaml:"host_port"`
+	Namespace      string `yaml:"namespace"`
+	TaskQueue      string `yaml:"task_queue"`
+	SkipDrainCheck bool   `yaml:"skip_drain_check"` // Worker 启动时不检查其他代码版本启动的流程，见 workflow_version.go
+}
+
+// AuthConfig 操作人身份认证配置，见 auth.go
+type AuthConfig struct {
+	Secret     string `yaml:"secret"` // 网关签名密钥，未配置时不校验签名
+	SecretFile string `yaml:"secret_file"`
+}
+
+// NotifySettings 通知渠道配置，未配置地址的渠道不启用
+type NotifySettings struct {
+	DefaultChannels []string        `yaml:"default_channels"`
+	Webhook         WebhookSettings `yaml:"webhook"`
+	DingTalk        WebhookSettings `yaml:"dingtalk"`
+	WeCom           WebhookSettings `yaml:"wecom"`
+	SMTP            SMTPSettings    `yaml:"smtp"`
+}
+
+// WebhookSettings Webhook 类渠道配置
+type WebhookSettings struct {
+	URL        string `yaml:"url"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// SMTPSettings 邮件渠道配置
+type SMTPSettings struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	From         string `yaml:"from"`
+}
+
+// redacted 启动日志中代替敏感值
+const redacted = "******"
+
+// defaultConfig 默认配置，连接本机的 MySQL 和 Temporal
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8082",
+			FrontendPath:    "../frontend/index.html",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver: DriverMySQL,
+			Path:   "upgrade_workflow.db",
+			Host:   "127.0.0.1",
+			User:   "root",
+			Name:   "upgrade_workflow",
+		},
+		Temporal: TemporalConfig{
+			HostPort:  "127.0.0.1:7233",
+			Namespace: "default",
+			TaskQueue: "upgrade-workflow-queue",
+		},
+	}
+}
+
+// loadConfig 按优先级加载配置，读取敏感文件并校验
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("upgrade-backend", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("UPGRADE_CONFIG"), "YAML 配置文件")
+	addr := fs.String("addr", "", "HTTP 监听地址")
+	frontend := fs.String("frontend", "", "前端页面路径")
+	dbDriver := fs.String("db-driver", "", "数据库驱动：mysql、postgres 或 sqlite")
+	dbPath := fs.String("db-path", "", "SQLite 数据库文件")
+	dbHost := fs.String("db-host", "", "数据库地址")
+	dbPort := fs.Int("db-port", 0, "数据库端口")
+	dbName := fs.String("db-name", "", "数据库名称")
+	temporalAddr := fs.String("temporal", "", "Temporal 地址")
+	taskQueue := fs.String("task-queue", "", "Temporal 任务队列")
+	skipDrainCheck := fs.Bool("skip-drain-check", false, "Worker 启动时不检查其他代码版本启动的流程")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// 只覆盖命令行中出现的参数
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "frontend":
+			cfg.Server.FrontendPath = *frontend
+		case "db-driver":
+			cfg.Database.Driver = *dbDriver
+		case "db-path":
+			cfg.Database.Path = *dbPath
+		case "db-host":
+			cfg.Database.Host = *dbHost
+		case "db-port":
+			cfg.Database.Port = *dbPort
+		case "db-name":
+			cfg.Database.Name = *dbName
+		case "temporal":
+			cfg.Temporal.HostPort = *temporalAddr
+		case "task-queue":
+			cfg.Temporal.TaskQueue = *taskQueue
+		case "skip-drain-check":
+			cfg.Temporal.SkipDrainCheck = *skipDrainCheck
+		}
+	})
+
+	if err := cfg.loadSecrets(); err != nil {
+		return nil, err
+	}
+	cfg.Database.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("配置文件 %s 无效: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv 读取环境变量，通知渠道沿用 NOTIFY_ 开头的变量名
+func (cfg *Config) loadEnv() error {
+	strs := map[string]*string{
+		"UPGRADE_HTTP_ADDR":           &cfg.Server.Addr,
+		"UPGRADE_FRONTEND_PATH":       &cfg.Server.FrontendPath,
+		"UPGRADE_DB_DRIVER":           &cfg.Database.Driver,
+		"UPGRADE_DB_PATH":             &cfg.Database.Path,
+		"UPGRADE_DB_HOST":             &cfg.Database.Host,
+		"UPGRADE_DB_USER":             &cfg.Database.User,
+		"UPGRADE_DB_PASSWORD":         &cfg.Database.Password,
+		"UPGRADE_DB_PASSWORD_FILE":    &cfg.Database.PasswordFile,
+		"UPGRADE_DB_NAME":             &cfg.Database.Name,
+		"UPGRADE_DB_PARAMS":           &cfg.Database.Params,
+		"UPGRADE_TEMPORAL_HOST_PORT":  &cfg.Temporal.HostPort,
+		"UPGRADE_TEMPORAL_NAMESPACE":  &cfg.Temporal.Namespace,
+		"UPGRADE_TASK_QUEUE":          &cfg.Temporal.TaskQueue,
+		"UPGRADE_AUTH_SECRET":         &cfg.Auth.Secret,
+		"UPGRADE_AUTH_SECRET_FILE":    &cfg.Auth.SecretFile,
+		"NOTIFY_WEBHOOK_URL":          &cfg.Notify.Webhook.URL,
+		"NOTIFY_WEBHOOK_SECRET":       &cfg.Notify.Webhook.Secret,
+		"NOTIFY_WEBHOOK_SECRET_FILE":  &cfg.Notify.Webhook.SecretFile,
+		"NOTIFY_DINGTALK_URL":         &cfg.Notify.DingTalk.URL,
+		"NOTIFY_DINGTALK_SECRET":      &cfg.Notify.DingTalk.Secret,
+		"NOTIFY_DINGTALK_SECRET_FILE": &cfg.Notify.DingTalk.SecretFile,
+		"NOTIFY_WECOM_URL":            &cfg.Notify.WeCom.URL,
+		"NOTIFY_SMTP_HOST":            &cfg.Notify.SMTP.Host,
+		"NOTIFY_SMTP_USERNAME":        &cfg.Notify.SMTP.Username,
+		"NOTIFY_SMTP_PASSWORD":        &cfg.Notify.SMTP.Password,
+		"NOTIFY_SMTP_PASSWORD_FILE":   &cfg.Notify.SMTP.PasswordFile,
+		"NOTIFY_SMTP_FROM":            &cfg.Notify.SMTP.From,
+	}
+	for name, target := range strs {
+		if v, ok := os.LookupEnv(name); ok {
+			*target = v
+		}
+	}
+
+	ints := map[string]*int{
+		"UPGRADE_DB_PORT":  &cfg.Database.Port,
+		"NOTIFY_SMTP_PORT": &cfg.Notify.SMTP.Port,
+	}
+	for name, target := range ints {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("环境变量 %s 不是整数: %s", name, v)
+			}
+			*target = n
+		}
+	}
+
+	if v, ok := os.LookupEnv("UPGRADE_SKIP_DRAIN_CHECK"); ok {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 UPGRADE_SKIP_DRAIN_CHECK 不是布尔值: %s", v)
+		}
+		cfg.Temporal.SkipDrainCheck = skip
+	}
+	if v, ok := os.LookupEnv("UPGRADE_SHUTDOWN_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("环境变量 UPGRADE_SHUTDOWN_TIMEOUT 不是时长: %s", v)
+		}
+		cfg.Server.ShutdownTimeout = d
+	}
+	if v, ok := os.LookupEnv("NOTIFY_DEFAULT_CHANNELS"); ok {
+		cfg.Notify.DefaultChannels = strings.Split(v, ",")
+	}
+	formats := map[string]string{"ITEM_ID_FORMAT": SequenceItem, "VERSION_ID_FORMAT": SequenceVersion}
+	for name, sequence := range formats {
+		if v, ok := os.LookupEnv(name); ok {
+			if cfg.IDFormats == nil {
+				cfg.IDFormats = make(map[string]string)
+			}
+			cfg.IDFormats[sequence] = v
+		}
+	}
+	return nil
+}
+
+// loadSecrets 从 xxx_file 读取敏感配置
+func (cfg *Config) loadSecrets() error {
+	secrets := []struct {
+		name  string
+		value *string
+		file  string
+	}{
+		{"database.password", &cfg.Database.Password, cfg.Database.PasswordFile},
+		{"auth.secret", &cfg.Auth.Secret, cfg.Auth.SecretFile},
+		{"notify.webhook.secret", &cfg.Notify.Webhook.Secret, cfg.Notify.Webhook.SecretFile},
+		{"notify.dingtalk.secret", &cfg.Notify.DingTalk.Secret, cfg.Notify.DingTalk.SecretFile},
+		{"notify.smtp.password", &cfg.Notify.SMTP.Password, cfg.Notify.SMTP.PasswordFile},
+	}
+	for _, s := range secrets {
+		if s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("%s 和 %s_file 不能同时配置", s.name, s.name)
+		}
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("读取 %s_file 失败: %w", s.name, err)
+		}
+		*s.value = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// validate 校验配置，返回所有错误
+func (cfg *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Server.Addr != "", "server.addr 不能为空")
+	check(cfg.Server.FrontendPath != "", "server.frontend_path 不能为空")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout 必须大于 0")
+	switch cfg.Database.Driver {
+	case DriverSQLite:
+		check(cfg.Database.Path != "", "database.path 不能为空")
+	case DriverMySQL, DriverPostgres:
+		check(cfg.Database.Host != "", "database.host 不能为空")
+		check(cfg.Database.Port > 0 && cfg.Database.Port < 65536, "database.port %d 无效", cfg.Database.Port)
+		check(cfg.Database.User != "", "database.user 不能为空")
+		check(cfg.Database.Name != "", "database.name 不能为空")
+	default:
+		check(false, "database.driver %s 无效，应为 mysql、postgres 或 sqlite", cfg.Database.Driver)
+	}
+	check(cfg.Temporal.HostPort != "", "temporal.host_port 不能为空")
+	check(cfg.Temporal.Namespace != "", "temporal.namespace 不能为空")
+	check(cfg.Temporal.TaskQueue != "", "temporal.task_queue 不能为空")
+
+	for _, channel := range cfg.Notify.DefaultChannels {
+		check(containsKey(allChannels, channel), "notify.default_channels 渠道 %s 无效", channel)
+	}
+	webhooks := []struct{ name, url string }{
+		{"notify.webhook.url", cfg.Notify.Webhook.URL},
+		{"notify.dingtalk.url", cfg.Notify.DingTalk.URL},
+		{"notify.wecom.url", cfg.Notify.WeCom.URL},
+	}
+	for _, w := range webhooks {
+		if w.url == "" {
+			continue
+		}
+		u, err := url.Parse(w.url)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "%s 无效", w.name)
+	}
+	if smtp := cfg.Notify.SMTP; smtp.Host != "" {
+		check(smtp.Port > 0 && smtp.Port < 65536, "notify.smtp.port %d 无效", smtp.Port)
+		check(smtp.From != "", "notify.smtp.from 不能为空")
+	}
+
+	sequences := make([]string, 0, len(cfg.IDFormats))
+	for sequence := range cfg.IDFormats {
+		sequences = append(sequences, sequence)
+	}
+	sort.Strings(sequences)
+	for _, sequence := range sequences {
+		if _, ok := idFormats[sequence]; !ok {
+			check(false, "id_formats 序列 %s 无效", sequence)
+		} else if _, err := parseIDFormat(cfg.IDFormats[sequence]); err != nil {
+			check(false, "id_formats.%s: %v", sequence, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("配置无效: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// applyDefaults 未配置端口和连接参数时使用驱动的默认值
+func (c *DatabaseConfig) applyDefaults() {
+	if c.Port == 0 {
+		c.Port = defaultDBPorts[c.Driver]
+	}
+	if c.Params == "" {
+		c.Params = defaultDBParams[c.Driver]
+	}
+}
+
+// 各驱动的默认端口和连接参数
+var (
+	defaultDBPorts = map[string]int{
+		DriverMySQL:    3306,
+		DriverPostgres: 5432,
+	}
+	defaultDBParams = map[string]string{
+		DriverMySQL:    "charset=utf8mb4&parseTime=True&loc=Local",
+		DriverPostgres: "sslmode=disable",
+		DriverSQLite:   "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	}
+)
+
+// DSN 数据库连接串，格式由驱动决定
+func (c DatabaseConfig) DSN() string {
+	switch c.Driver {
+	case DriverPostgres:
+		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
+			c.Host, c.Port, c.User, pgQuote(c.Password), c.Name)
+		if c.Params != "" {
+			dsn += " " + c.Params
+		}
+		return dsn
+	case DriverSQLite:
+		if c.Params != "" {
+			return c.Path + "?" + c.Params
+		}
+		return c.Path
+	}
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", c.User, c.Password, c.Host, c.Port, c.Name)
+	if c.Params != "" {
+		dsn += "?" + c.Params
+	}
+	return dsn
+}
+
+// pgQuote 按 PostgreSQL 连接串的规则给值加引号，密码中可能有空格和引号
+func pgQuote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(v) + "'"
+}
+
+// notifyConfig 按配置创建通知渠道
+func (s NotifySettings) notifyConfig() NotifyConfig {
+	cfg := NotifyConfig{DefaultChannels: s.DefaultChannels}
+	if s.Webhook.URL != "" {
+		cfg.Webhook = &WebhookNotifier{Kind: ChannelWebhook, URL: s.Webhook.URL, Secret: s.Webhook.Secret}
+	}
+	if s.DingTalk.URL != "" {
+		cfg.DingTalk = &WebhookNotifier{Kind: ChannelDingTalk, URL: s.DingTalk.URL, Secret: s.DingTalk.Secret}
+	}
+	if s.WeCom.URL != "" {
+		cfg.WeCom = &WebhookNotifier{Kind: ChannelWeCom, URL: s.WeCom.URL}
+	}
+	if s.SMTP.Host != "" {
+		cfg.SMTP = &SMTPNotifier{
+			Host:     s.SMTP.Host,
+			Port:     s.SMTP.Port,
+			Username: s.SMTP.Username,
+			Password: s.SMTP.Password,
+			From:     s.SMTP.From,
+		}
+	}
+	return cfg
+}
+
+// Redacted 隐藏敏感值的配置文本，用于启动日志
+// Webhook 地址中可能带有 access_token，一并隐藏查询参数
+func (cfg *Config) Redacted() string {
+	c := *cfg
+	hide := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redacted
+	}
+	hideQuery := func(raw string) string {
+		if u, err := url.Parse(raw); err == nil && u.RawQuery != "" {
+			u.RawQuery = redacted
+			return u.String()
+		}
+		return raw
+	}
+	c.Database.Password = hide(c.Database.Password)
+	c.Auth.Secret = hide(c.Auth.Secret)
+	c.Notify.Webhook.Secret = hide(c.Notify.Webhook.Secret)
+	c.Notify.Webhook.URL = hideQuery(c.Notify.Webhook.URL)
+	c.Notify.DingTalk.Secret = hide(c.Notify.DingTalk.Secret)
+	c.Notify.DingTalk.URL = hideQuery(c.Notify.DingTalk.URL)
+	c.Notify.WeCom.URL = hideQuery(c.Notify.WeCom.URL)
+	c.Notify.SMTP.Password = hide(c.Notify.SMTP.Password)
+
+	out, err := yaml.Marshal(&c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 版本取消、暂停与恢复
+// 通过 Signal 控制运行中的版本：
+//   暂停：阶段计时器（审批超时、提醒、测试超时）停止计时，收到的审批和测试结果在恢复后处理
+//   恢复：计时器从暂停时的剩余时长继续，截止时间顺延
+//   取消：结束所有进行中的阶段，恢复条目在版本开始时的状态，版本标记为已取消
+// ============================================================
+
+// versionControl 运行中版本的控制状态
+type versionControl struct {
+	cancelled workflow.Future // 收到取消请求后就绪
+	request   *VersionControl // 取消请求
+}
+
+// listenVersionControl 在后台处理取消/暂停/恢复 Signal
+func listenVersionControl(ctx workflow.Context, state *WorkflowState, version UpgradeVersion) *versionControl {
+	cancelled, setCancelled := workflow.NewFuture(ctx)
+	vc := &versionControl{cancelled: cancelled}
+
+	pauseChan := workflow.GetSignalChannel(ctx, SignalPause)
+	resumeChan := workflow.GetSignalChannel(ctx, SignalResume)
+	cancelChan := workflow.GetSignalChannel(ctx, SignalCancel)
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var signal VersionControl
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(pauseChan, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, &signal)
+				pauseVersion(ctx, state, version, signal)
+			})
+			selector.AddReceive(resumeChan, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, &signal)
+				resumeVersion(ctx, state, version, signal)
+			})
+			selector.AddReceive(cancelChan, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, &signal)
+				if vc.request != nil {
+					logger.Info("版本已在取消中，忽略重复的取消请求", zap.String("operator", signal.Operator))
+					return
+				}
+				vc.request = &signal
+				setCancelled.Set(signal, nil)
+			})
+			selector.Select(ctx)
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	})
+	return vc
+}
+
+func pauseVersion(ctx workflow.Context, state *WorkflowState, version UpgradeVersion, signal VersionControl) {
+	if state.paused() {
+		logger.Info("版本已暂停，忽略暂停请求", zap.String("operator", signal.Operator))
+		return
+	}
+	state.pause(ctx, signal)
+	recordAudit(ctx, state, AuditEvent{EventType: AuditPause, Operator: signal.Operator, Comment: signal.Reason})
+	updateVersionStatus(ctx, version.ID, "paused")
+
+	message := fmt.Sprintf("版本 %s 已被 %s 暂停", version.Name, signal.Operator)
+	if signal.Reason != "" {
+		message += "：" + signal.Reason
+	}
+	notify(ctx, Notification{
+		VersionID:  version.ID,
+		Event:      NotifyEventPause,
+		Recipients: versionMembers(version),
+		Message:    message,
+	})
+	logger.Info("版本已暂停", zap.String("versionId", version.ID), zap.String("operator", signal.Operator))
+}
+
+func resumeVersion(ctx workflow.Context, state *WorkflowState, version UpgradeVersion, signal VersionControl) {
+	if !state.paused() {
+		logger.Info("版本未暂停，忽略恢复请求", zap.String("operator", signal.Operator))
+		return
+	}
+	paused := state.resume(ctx)
+	recordAudit(ctx, state, AuditEvent{
+		EventType: AuditResume,
+		Operator:  signal.Operator,
+		Comment:   fmt.Sprintf("暂停 %s，截止时间相应顺延", paused.Round(time.Second)),
+	})
+	updateVersionStatus(ctx, version.ID, "running")
+
+	notify(ctx, Notification{
+		VersionID:  version.ID,
+		Event:      NotifyEventResume,
+		Recipients: versionMembers(version),
+		Message:    fmt.Sprintf("版本 %s 已被 %s 恢复", version.Name, signal.Operator),
+	})
+	logger.Info("版本已恢复",
+		zap.String("versionId", version.ID),
+		zap.String("operator", signal.Operator),
+		zap.Duration("paused", paused))
+}
+
+// compensateCancel 取消后的补偿：记录审计、恢复条目状态并通知版本人员
+// items 为版本中剩余的条目，保存的是版本开始时的状态
+func compensateCancel(ctx workflow.Context, state *WorkflowState, version UpgradeVersion, items []UpgradeItem, signal VersionControl) {
+	state.Pause = nil
+	recordAudit(ctx, state, AuditEvent{EventType: AuditCancel, Operator: signal.Operator, Comment: signal.Reason})
+
+	restoreReq := RestoreItemsRequest{VersionID: version.ID, Items: items}
+	if err := workflow.ExecuteActivity(persistContext(ctx), RestoreItemsActivity, restoreReq).Get(ctx, nil); err != nil {
+		logger.Error("取消版本时恢复条目状态失败", zap.String("versionId", version.ID), zap.Error(err))
+	}
+
+	notify(ctx, Notification{
+		VersionID:  version.ID,
+		Event:      NotifyEventCancel,
+		Recipients: versionMembers(version),
+		Message:    fmt.Sprintf("版本 %s 已被 %s 取消：%s", version.Name, signal.Operator, signal.Reason),
+	})
+	logger.Info("版本已取消",
+		zap.String("versionId", version.ID),
+		zap.String("operator", signal.Operator),
+		zap.Int("restoredItems", len(items)))
+}
+
+// updateVersionStatus 持久化版本的运行状态
+func updateVersionStatus(ctx workflow.Context, versionID, status string) {
+	req := VersionStatusUpdate{VersionID: versionID, Status: status}
+	if err := workflow.ExecuteActivity(persistContext(ctx), UpdateVersionStatusActivity, req).Get(ctx, nil); err != nil {
+		logger.Error("版本状态持久化失败", zap.String("versionId", versionID), zap.String("status", status), zap.Error(err))
+	}
+}
+
+// versionMembers 版本中担任各角色的人员，去重
+func versionMembers(version UpgradeVersion) []string {
+	var members []string
+	for _, role := range allRoles {
+		if member := roleMember(role, version); member != "" && !containsKey(members, member) {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// ============================================================
+// 可暂停的计时器
+// ============================================================
+
+// newPausableTimer 只在版本未暂停时计时的计时器
+func newPausableTimer(ctx workflow.Context, state *WorkflowState, d time.Duration) workflow.Future {
+	future, settable := workflow.NewFuture(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		settable.Set(nil, pausableSleep(ctx, state, d))
+	})
+	return future
+}
+
+// pausableSleep 等待 d 的未暂停时长，暂停期间不计时
+func pausableSleep(ctx workflow.Context, state *WorkflowState, d time.Duration) error {
+	remaining := d
+	for remaining > 0 {
+		if err := awaitResume(ctx, state); err != nil {
+			return err
+		}
+		start := workflow.Now(ctx)
+		paused, err := workflow.AwaitWithTimeout(ctx, remaining, state.paused)
+		if err != nil {
+			return err
+		}
+		if !paused {
+			return nil
+		}
+		remaining -= workflow.Now(ctx).Sub(start)
+	}
+	return nil
+}
+
+// awaitResume 版本暂停时等待恢复
+func awaitResume(ctx workflow.Context, state *WorkflowState) error {
+	return workflow.Await(ctx, func() bool { return !state.paused() })
+}
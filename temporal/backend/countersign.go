@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================
+// 审批会签
+// 审批人由阶段角色对应的版本人员确定，同一人担任多个角色时只算一名审批人：
+//   any    任一审批人通过即可（默认）
+//   all    所有审批人都通过
+//   quorum 至少 Quorum 个审批人通过
+// 剩余未表态的审批人全部通过也达不到要求人数时，阶段被驳回
+// 超时升级后，版本负责人的表态直接决定阶段结果
+// ============================================================
+
+// countersign 阶段会签进度
+type countersign struct {
+	approvers []string                  // 审批人，按角色顺序
+	required  int                       // 需要的通过人数
+	decisions map[string]ApprovalAction // 审批人 -> 最近一次表态
+	signers   []string                  // 已表态的审批人，按首次表态顺序
+
+	escalatedTo string          // 超时升级后的决定人，其表态直接决定阶段结果
+	override    *ApprovalAction // 升级对象的表态
+}
+
+func newCountersign(stage StageConfig, approvers []string) *countersign {
+	return &countersign{
+		approvers: approvers,
+		required:  requiredApprovals(stage, len(approvers)),
+		decisions: make(map[string]ApprovalAction),
+	}
+}
+
+// requiredApprovals 阶段需要的通过人数，不超过审批人数且至少为 1
+func requiredApprovals(stage StageConfig, approvers int) int {
+	required := 1
+	switch stage.ApprovalMode {
+	case ApprovalModeAll:
+		required = approvers
+	case ApprovalModeQuorum:
+		required = stage.Quorum
+	}
+	if required > approvers {
+		required = approvers
+	}
+	if required < 1 {
+		required = 1
+	}
+	return required
+}
+
+// record 记录审批人的表态，同一审批人再次表态时覆盖之前的结果
+// 未配置审批人时任何操作人都可表态；返回 false 表示操作人不是该阶段的审批人
+func (cs *countersign) record(action ApprovalAction) bool {
+	escalated := cs.escalatedTo != "" && action.Operator == cs.escalatedTo
+	if escalated {
+		cs.override = &action
+	}
+	if len(cs.approvers) > 0 && !containsKey(cs.approvers, action.Operator) {
+		return escalated
+	}
+	if _, ok := cs.decisions[action.Operator]; !ok {
+		cs.signers = append(cs.signers, action.Operator)
+	}
+	cs.decisions[action.Operator] = action
+	return true
+}
+
+// approved 通过人数达到要求
+func (cs *countersign) approved() bool {
+	if cs.override != nil {
+		return cs.override.Approved
+	}
+	return cs.count(true) >= cs.required
+}
+
+// rejected 剩余审批人全部通过也达不到要求人数
+func (cs *countersign) rejected() bool {
+	if cs.override != nil {
+		return !cs.override.Approved
+	}
+	total := len(cs.approvers)
+	if total == 0 {
+		total = len(cs.decisions)
+	}
+	return total-cs.count(false) < cs.required
+}
+
+func (cs *countersign) count(approved bool) int {
+	n := 0
+	for _, action := range cs.decisions {
+		if action.Approved == approved {
+			n++
+		}
+	}
+	return n
+}
+
+// reset 驳回后重新会签
+func (cs *countersign) reset() {
+	cs.decisions = make(map[string]ApprovalAction)
+	cs.signers = nil
+	cs.override = nil
+}
+
+// escalate 超时升级，之后由 to 的表态决定阶段结果
+func (cs *countersign) escalate(to string) {
+	cs.escalatedTo = to
+	cs.override = nil
+}
+
+// pending 尚未表态的审批人
+func (cs *countersign) pending() []string {
+	var pending []string
+	for _, approver := range cs.approvers {
+		if _, ok := cs.decisions[approver]; !ok {
+			pending = append(pending, approver)
+		}
+	}
+	if cs.escalatedTo != "" && cs.override == nil && !containsKey(pending, cs.escalatedTo) {
+		pending = append(pending, cs.escalatedTo)
+	}
+	return pending
+}
+
+// signatures 已表态的审批记录
+func (cs *countersign) signatures() []ApprovalAction {
+	signatures := make([]ApprovalAction, 0, len(cs.signers))
+	for _, signer := range cs.signers {
+		signatures = append(signatures, cs.decisions[signer])
+	}
+	return signatures
+}
+
+// approvedBy 通过的审批人
+func (cs *countersign) approvedBy() string {
+	var approved []string
+	for _, signer := range cs.signers {
+		if cs.decisions[signer].Approved {
+			approved = append(approved, signer)
+		}
+	}
+	return strings.Join(approved, "、")
+}
+
+// summary 会签结果说明，用作阶段结束信息
+func (cs *countersign) summary() string {
+	return fmt.Sprintf("%d/%d 人通过（需要 %d 人）", cs.count(true), len(cs.approvers), cs.required)
+}
+
+// validateApprovalMode 校验阶段会签配置
+// 流程配置不知道版本人员，会签人数按阶段角色数校验；实际人数在创建版本时由 validateVersionApprovers 校验
+func validateApprovalMode(v *flowValidator, field string, stage StageConfig) {
+	switch stage.ApprovalMode {
+	case "", ApprovalModeAny, ApprovalModeAll:
+	case ApprovalModeQuorum:
+		if roles := len(stageRoles(stage)); stage.Quorum < 1 || stage.Quorum > roles {
+			v.add(field+".quorum", "会签人数 %d 必须在 1 到 %d 之间", stage.Quorum, roles)
+		}
+	default:
+		v.add(field+".approval_mode", "会签方式 %s 无效", stage.ApprovalMode)
+	}
+}
+
+// validateVersionApprovers 校验版本人员能满足各阶段的会签人数
+// 同一人担任阶段的多个角色时审批人少于角色数，会签人数超过审批人数的版本不能创建
+func validateVersionApprovers(stages []StageConfig, version UpgradeVersion) error {
+	for _, stage := range stages {
+		if !stage.Enabled || stage.Type == "test" || stage.ApprovalMode != ApprovalModeQuorum {
+			continue
+		}
+		if approvers := len(stageApprovers(stage, version)); approvers > 0 && stage.Quorum > approvers {
+			return fmt.Errorf("%s阶段需要 %d 人会签，版本中只有 %d 名不同的审批人", stage.Name, stage.Quorum, approvers)
+		}
+	}
+	return nil
+}
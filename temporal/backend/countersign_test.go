@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+// TestCountersignOneApproverHoldingTwoRoles 同一人担任阶段的两个角色时只需表态一次
+func TestCountersignOneApproverHoldingTwoRoles(t *testing.T) {
+	stage := StageConfig{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true,
+		Roles: []string{RoleVersionOwner, RoleVendorOwner}, ApprovalMode: ApprovalModeAll}
+	version := UpgradeVersion{VersionOwner: "alice", VendorOwner: "alice"}
+
+	approvers := stageApprovers(stage, version)
+	if len(approvers) != 1 || approvers[0] != "alice" {
+		t.Fatalf("审批人应去重: %v", approvers)
+	}
+
+	cs := newCountersign(stage, approvers)
+	if cs.required != 1 {
+		t.Fatalf("需要的通过人数 %d，期望 1", cs.required)
+	}
+	cs.record(ApprovalAction{Operator: "alice", Approved: true})
+	if !cs.approved() {
+		t.Fatal("唯一的审批人通过后阶段应通过")
+	}
+
+	cs.reset()
+	cs.record(ApprovalAction{Operator: "alice", Approved: false})
+	if !cs.rejected() {
+		t.Fatal("唯一的审批人驳回后阶段应驳回")
+	}
+}
+
+func TestValidateVersionApprovers(t *testing.T) {
+	stages := []StageConfig{{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true,
+		Roles: []string{RoleVersionOwner, RoleVendorOwner}, ApprovalMode: ApprovalModeQuorum, Quorum: 2}}
+
+	if err := validateVersionApprovers(stages, UpgradeVersion{VersionOwner: "alice", VendorOwner: "bob"}); err != nil {
+		t.Fatalf("两名审批人满足会签人数: %v", err)
+	}
+	if err := validateVersionApprovers(stages, UpgradeVersion{VersionOwner: "alice", VendorOwner: "alice"}); err == nil {
+		t.Fatal("同一人担任两个角色时不满足 2 人会签，应返回错误")
+	}
+
+	v := &flowValidator{}
+	validateApprovalMode(v, "stages[0]", StageConfig{Roles: []string{RoleVersionOwner, RoleVendorOwner}, ApprovalMode: ApprovalModeQuorum, Quorum: 3})
+	if v.result() == nil {
+		t.Fatal("会签人数超过角色数时流程配置应校验失败")
+	}
+}
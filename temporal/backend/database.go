@@ -2,29 +2,56 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
-	"gorm.io/driver/mysql"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var db *gorm.DB
-
 // ============================================================
 // 数据库模型
 // ============================================================
 
 // FlowConfig 流程配置
 type FlowConfig struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	Name        string    `gorm:"size:100;not null" json:"name"`
-	Description string    `gorm:"size:500" json:"description"`
-	Stages      string    `gorm:"type:text" json:"stages"` // JSON 数组
-	IsDefault   bool      `gorm:"default:false" json:"is_default"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"size:100;not null" json:"name"`
+	Description string     `gorm:"size:500" json:"description"`
+	Stages      string     `gorm:"type:text" json:"stages"` // JSON 数组
+	IsDefault   bool       `gorm:"default:false" json:"is_default"`
+	IsEmergency bool       `gorm:"default:false" json:"is_emergency"`
+	Revision    int        `json:"revision"` // 最新修订号
+	Archived    bool       `gorm:"default:false" json:"archived"`
+	ArchivedAt  *time.Time `json:"archived_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// FlowRevision 流程配置修订，每次编辑生成一个新修订，创建后不可修改
+// 版本创建时固定使用当时的最新修订
+type FlowRevision struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	FlowConfigID uint      `gorm:"uniqueIndex:idx_flow_revision" json:"flow_config_id"`
+	Revision     int       `gorm:"uniqueIndex:idx_flow_revision" json:"revision"`
+	Name         string    `gorm:"size:100;not null" json:"name"`
+	Description  string    `gorm:"size:500" json:"description"`
+	Stages       string    `gorm:"type:text" json:"stages"` // JSON 数组
+	CreatedAt    time.Time `json:"created_at"`
 }
 
+func (FlowRevision) TableName() string { return "upgrade_flow_config_revisions" }
+
+var errRevisionImmutable = errors.New("流程配置修订不可修改")
+
+func (FlowRevision) BeforeUpdate(tx *gorm.DB) error { return errRevisionImmutable }
+
+func (FlowRevision) BeforeDelete(tx *gorm.DB) error { return errRevisionImmutable }
+
 // StageConfig 阶段配置（用于 JSON 序列化）
 type StageConfig struct {
 	Key      string `json:"key"`
@@ -33,7 +60,24 @@ type StageConfig struct {
 	Enabled  bool   `json:"enabled"`
 	Timeout  int    `json:"timeout"`   // 超时时间（小时）
 	AutoPass bool   `json:"auto_pass"` // 超时是否自动通过
+	OnFail   string `json:"on_fail"`   // 测试不通过处理策略：fail/suspend
 	Order    int    `json:"order"`
+
+	Roles      []string `json:"roles,omitempty"`       // 可操作角色，为空时按阶段默认
+	ReturnTo   string   `json:"return_to,omitempty"`   // 驳回/测试不通过时退回的阶段
+	MaxReturns int      `json:"max_returns,omitempty"` // 最大退回次数，0 表示不限
+	DependsOn  []string `json:"depends_on,omitempty"`  // 前置依赖阶段，为空时依赖前一个阶段
+
+	ApprovalMode string `json:"approval_mode,omitempty"` // 会签方式：any/all/quorum，为空时任一审批人通过
+	Quorum       int    `json:"quorum,omitempty"`        // quorum 方式需要的通过人数
+
+	Reminders   []int  `json:"reminders,omitempty"`    // 超时提醒时间点（超时时间的百分比），如 [50, 90]
+	Escalation  string `json:"escalation,omitempty"`   // 审批超时处理：fail/escalate，为空时直接失败
+	ExtendHours int    `json:"extend_hours,omitempty"` // 升级后延长的时间（小时），为空时与 Timeout 相同
+
+	UrgentTimeout int `json:"urgent_timeout,omitempty"` // 紧急版本的超时时间（小时），为空时按比例压缩
+
+	Templates map[string]string `json:"templates,omitempty"` // 通知模板：事件 -> 模板
 }
 
 // ItemModel 条目模型
@@ -52,148 +96,288 @@ type ItemModel struct {
 	BTEResult     string    `gorm:"size:50" json:"bte_result"`
 	GrayResult    string    `gorm:"size:50" json:"gray_result"`
 	ProdResult    string    `gorm:"size:50" json:"prod_result"`
+	CloseReason   string    `gorm:"size:500" json:"close_reason"`
+	TestCases     string    `gorm:"type:text" json:"test_cases"`        // JSON 数组
+	VersionID     string    `gorm:"size:50;index" json:"version_id"`    // 占用条目的版本，为空表示未加入运行中的版本
+	Revision      int       `gorm:"not null;default:0" json:"revision"` // 乐观锁版本号，每次修改加 1
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 func (ItemModel) TableName() string { return "upgrade_items" }
 
+// TestCaseList 条目的测试用例
+func (m ItemModel) TestCaseList() []string {
+	var cases []string
+	json.Unmarshal([]byte(m.TestCases), &cases)
+	return cases
+}
+
+// nextRevision 修改条目时递增乐观锁版本号，所有修改条目的操作都需要带上
+var nextRevision = gorm.Expr("revision + 1")
+
 // VersionModel 版本模型
 type VersionModel struct {
-	ID           string    `gorm:"primaryKey;size:50" json:"id"`
-	Name         string    `gorm:"size:200;not null" json:"name"`
-	VersionOwner string    `gorm:"size:100" json:"version_owner"`
-	VendorOwner  string    `gorm:"size:100" json:"vendor_owner"`
-	BTETester    string    `gorm:"size:100" json:"bte_tester"`
-	GrayTester   string    `gorm:"size:100" json:"gray_tester"`
-	ProdTester   string    `gorm:"size:100" json:"prod_tester"`
-	IsUrgent     bool      `json:"is_urgent"`
-	Status       string    `gorm:"size:50" json:"status"`
-	CurrentStage string    `gorm:"size:50" json:"current_stage"`
-	ItemIDs      string    `gorm:"type:text" json:"item_ids"` // JSON 数组
-	FlowConfigID uint      `json:"flow_config_id"`
-	WorkflowID   string    `gorm:"size:100" json:"workflow_id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID             string     `gorm:"primaryKey;size:50" json:"id"`
+	Name           string     `gorm:"size:200;not null" json:"name"`
+	VersionOwner   string     `gorm:"size:100" json:"version_owner"`
+	VendorOwner    string     `gorm:"size:100" json:"vendor_owner"`
+	BTETester      string     `gorm:"size:100" json:"bte_tester"`
+	GrayTester     string     `gorm:"size:100" json:"gray_tester"`
+	ProdTester     string     `gorm:"size:100" json:"prod_tester"`
+	IsUrgent       bool       `json:"is_urgent"`
+	UrgentReason   string     `gorm:"size:500" json:"urgent_reason"` // 紧急升级理由
+	Status         string     `gorm:"size:50" json:"status"`
+	CurrentStage   string     `gorm:"size:50" json:"current_stage"`
+	ItemIDs        string     `gorm:"type:text" json:"item_ids"` // JSON 数组
+	FlowConfigID   uint       `json:"flow_config_id"`
+	FlowRevisionID uint       `json:"flow_revision_id"` // 创建时固定的流程配置修订
+	WorkflowID     string     `gorm:"size:100" json:"workflow_id"`
+	Message        string     `gorm:"size:500" json:"message"` // 流程结果说明
+	CompletedAt    *time.Time `json:"completed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
 func (VersionModel) TableName() string { return "upgrade_versions" }
 
-// ============================================================
-// 数据库初始化
-// ============================================================
+// SuspensionModel 条目挂起记录，由版本负责人确认
+type SuspensionModel struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	VersionID   string     `gorm:"size:50;index" json:"version_id"`
+	ItemID      string     `gorm:"size:50" json:"item_id"`
+	Stage       string     `gorm:"size:50" json:"stage"`
+	Reason      string     `gorm:"size:500" json:"reason"`
+	Confirmed   bool       `json:"confirmed"`
+	ConfirmedBy string     `gorm:"size:100" json:"confirmed_by"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
 
-func initDatabase() error {
-	dsn := "root:Nuttertools1103..@tcp(127.0.0.1:3306)/upgrade_workflow?charset=utf8mb4&parseTime=True&loc=Local"
+func (SuspensionModel) TableName() string { return "upgrade_item_suspensions" }
 
-	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return err
-	}
+// ReleaseNoteModel 版本发布说明，版本完成时生成，重复生成时覆盖
+type ReleaseNoteModel struct {
+	VersionID string    `gorm:"primaryKey;size:50" json:"version_id"`
+	Markdown  string    `json:"markdown"`
+	HTML      string    `json:"html"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
 
-	// 自动迁移
-	err = db.AutoMigrate(&FlowConfig{}, &ItemModel{}, &VersionModel{})
-	if err != nil {
-		return err
-	}
+func (ReleaseNoteModel) TableName() string { return "upgrade_release_notes" }
+
+// ItemTransitionModel 条目状态变更历史
+type ItemTransitionModel struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ItemID     string    `gorm:"size:50;index" json:"item_id"`
+	VersionID  string    `gorm:"size:50" json:"version_id"` // 手工变更时为空
+	Stage      string    `gorm:"size:50" json:"stage"`
+	FromStatus string    `gorm:"size:50" json:"from_status"`
+	ToStatus   string    `gorm:"size:50" json:"to_status"`
+	Operator   string    `gorm:"size:100" json:"operator"`
+	Reason     string    `gorm:"size:500" json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
 
-	// 初始化默认流程配置
-	initDefaultFlowConfig()
+func (ItemTransitionModel) TableName() string { return "upgrade_item_transitions" }
 
-	logger.Info("数据库连接成功")
-	return nil
+// StageHistoryModel 阶段历史，由工作流在进入/结束阶段时写入
+type StageHistoryModel struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	VersionID   string     `gorm:"size:50;index" json:"version_id"`
+	Stage       string     `gorm:"size:50" json:"stage"`
+	StageName   string     `gorm:"size:100" json:"stage_name"`
+	Status      string     `gorm:"size:20" json:"status"`  // in_progress/completed/failed/returned
+	Outcome     string     `gorm:"size:20" json:"outcome"` // approved/auto_pass/passed/failed/timeout/rejected
+	Operator    string     `gorm:"size:100" json:"operator"`
+	Message     string     `gorm:"size:500" json:"message"`
+	Iteration   int        `json:"iteration"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
 }
 
-// initDefaultFlowConfig 初始化默认流程配置
-func initDefaultFlowConfig() {
-	var count int64
-	db.Model(&FlowConfig{}).Count(&count)
-	if count > 0 {
-		return
-	}
-
-	// 默认完整流程
-	defaultStages := []StageConfig{
-		{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
-		{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
-		{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
-		{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
-		{Key: StageGrayConfirm, Name: "灰度条目确认", Type: "approval", Enabled: true, Timeout: 48, Order: 5},
-		{Key: StageGrayFinalize, Name: "灰度定版", Type: "approval", Enabled: true, Timeout: 24, Order: 6},
-		{Key: StageGrayPrepare, Name: "灰度版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 7},
-		{Key: StageGrayTest, Name: "灰度测试", Type: "test", Enabled: true, Timeout: 96, Order: 8},
-		{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 9},
-		{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 10},
-		{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 11},
-		{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 12},
-		{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 13},
-	}
-
-	stagesJSON, _ := json.Marshal(defaultStages)
-	defaultConfig := FlowConfig{
-		Name:        "默认升级流程",
-		Description: "包含完整的 BTE → 灰度 → 生产 测试流程",
-		Stages:      string(stagesJSON),
-		IsDefault:   true,
-	}
-	db.Create(&defaultConfig)
-
-	// 简化流程（跳过灰度）
-	simpleStages := []StageConfig{
-		{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
-		{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
-		{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
-		{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
-		{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 5},
-		{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 6},
-		{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 7},
-		{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 8},
-		{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 9},
-	}
-	simpleJSON, _ := json.Marshal(simpleStages)
-	simpleConfig := FlowConfig{
-		Name:        "紧急升级流程",
-		Description: "跳过灰度测试，直接进入生产",
-		Stages:      string(simpleJSON),
-		IsDefault:   false,
-	}
-	db.Create(&simpleConfig)
-
-	logger.Info("默认流程配置已初始化")
+func (StageHistoryModel) TableName() string { return "upgrade_stage_history" }
+
+// AuditModel 审计记录，只允许插入
+// Activity 重试时同一事件（版本、阶段、类型、条目、操作人、时间都相同）只保留一条，不同审批人同时会签各自记录
+type AuditModel struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	VersionID  string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe" json:"version_id"`
+	Stage      string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe" json:"stage"`
+	EventType  string    `gorm:"size:20;uniqueIndex:idx_audit_dedupe" json:"event_type"`
+	ItemID     string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe" json:"item_id"`
+	Operator   string    `gorm:"size:100;uniqueIndex:idx_audit_dedupe" json:"operator"`
+	Passed     bool      `json:"passed"`
+	Comment    string    `gorm:"size:500" json:"comment"`
+	OccurredAt time.Time `gorm:"uniqueIndex:idx_audit_dedupe" json:"occurred_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (AuditModel) TableName() string { return "upgrade_audit_logs" }
+
+var errAuditImmutable = errors.New("审计记录不可修改")
+
+func (AuditModel) BeforeUpdate(tx *gorm.DB) error { return errAuditImmutable }
+
+func (AuditModel) BeforeDelete(tx *gorm.DB) error { return errAuditImmutable }
+
+// NotifyPreference 用户通知偏好
+type NotifyPreference struct {
+	User      string    `gorm:"primaryKey;column:username;size:100" json:"user"`
+	Channels  string    `gorm:"size:200" json:"channels"` // 逗号分隔的渠道，如 dingtalk,email
+	Email     string    `gorm:"size:200" json:"email"`
+	Mobile    string    `gorm:"size:50" json:"mobile"` // 群机器人 @ 用的手机号
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (NotifyPreference) TableName() string { return "upgrade_notify_preferences" }
+
+// ChannelList 偏好的渠道列表
+func (p NotifyPreference) ChannelList() []string {
+	var channels []string
+	for _, channel := range strings.Split(p.Channels, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
 }
 
 // ============================================================
 // 数据库操作
 // ============================================================
 
-// GetFlowConfigs 获取所有流程配置
-func GetFlowConfigs() ([]FlowConfig, error) {
+// GetFlowConfigs 获取流程配置，includeArchived 为 false 时不包含已归档的配置
+func (s *gormStore) GetFlowConfigs(includeArchived bool) ([]FlowConfig, error) {
 	var configs []FlowConfig
-	err := db.Find(&configs).Error
+	query := s.db
+	if !includeArchived {
+		query = query.Where("archived = ?", false)
+	}
+	err := query.Find(&configs).Error
 	return configs, err
 }
 
+// GetDefaultFlowConfig 获取未归档的默认流程配置
+func (s *gormStore) GetDefaultFlowConfig() (*FlowConfig, error) {
+	var config FlowConfig
+	err := s.db.Where("is_default = ? AND archived = ?", true, false).First(&config).Error
+	return &config, err
+}
+
+// GetEmergencyFlowConfig 获取未归档的紧急流程配置
+func (s *gormStore) GetEmergencyFlowConfig() (*FlowConfig, error) {
+	var config FlowConfig
+	err := s.db.Where("is_emergency = ? AND archived = ?", true, false).First(&config).Error
+	return &config, err
+}
+
+// SetEmergencyFlowConfig 指定紧急流程配置，同时只有一个
+func (s *gormStore) SetEmergencyFlowConfig(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&FlowConfig{}).Where("is_emergency = ? AND id <> ?", true, id).
+			Update("is_emergency", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&FlowConfig{}).Where("id = ?", id).Update("is_emergency", true).Error
+	})
+}
+
 // GetFlowConfig 获取单个流程配置
-func GetFlowConfig(id uint) (*FlowConfig, error) {
+func (s *gormStore) GetFlowConfig(id uint) (*FlowConfig, error) {
 	var config FlowConfig
-	err := db.First(&config, id).Error
+	err := s.db.First(&config, id).Error
 	return &config, err
 }
 
-// CreateFlowConfig 创建流程配置
-func CreateFlowConfig(config *FlowConfig) error {
-	return db.Create(config).Error
+// CreateFlowConfig 创建流程配置及其第一个修订
+func (s *gormStore) CreateFlowConfig(config *FlowConfig) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		config.Revision = 1
+		if err := tx.Create(config).Error; err != nil {
+			return err
+		}
+		return tx.Create(newFlowRevision(config)).Error
+	})
+}
+
+// UpdateFlowConfig 更新流程配置，生成新的修订；已有版本仍使用各自固定的修订
+func (s *gormStore) UpdateFlowConfig(config *FlowConfig) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		config.Revision++
+		if err := tx.Create(newFlowRevision(config)).Error; err != nil {
+			return err
+		}
+		return tx.Save(config).Error
+	})
+}
+
+// ArchiveFlowConfig 归档流程配置，归档后不能再用于新版本，已有版本不受影响
+func (s *gormStore) ArchiveFlowConfig(id uint) error {
+	now := time.Now()
+	return s.db.Model(&FlowConfig{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"archived": true, "archived_at": &now}).Error
+}
+
+func newFlowRevision(config *FlowConfig) *FlowRevision {
+	return &FlowRevision{
+		FlowConfigID: config.ID,
+		Revision:     config.Revision,
+		Name:         config.Name,
+		Description:  config.Description,
+		Stages:       config.Stages,
+	}
+}
+
+// GetFlowRevisions 获取流程配置的所有修订，按修订号排序
+func (s *gormStore) GetFlowRevisions(flowConfigID uint) ([]FlowRevision, error) {
+	var revisions []FlowRevision
+	err := s.db.Where("flow_config_id = ?", flowConfigID).Order("revision").Find(&revisions).Error
+	return revisions, err
 }
 
-// UpdateFlowConfig 更新流程配置
-func UpdateFlowConfig(config *FlowConfig) error {
-	return db.Save(config).Error
+// GetFlowRevision 按修订号获取流程配置修订
+func (s *gormStore) GetFlowRevision(flowConfigID uint, revision int) (*FlowRevision, error) {
+	var rev FlowRevision
+	err := s.db.Where("flow_config_id = ? AND revision = ?", flowConfigID, revision).First(&rev).Error
+	return &rev, err
 }
 
-// DeleteFlowConfig 删除流程配置
-func DeleteFlowConfig(id uint) error {
-	return db.Delete(&FlowConfig{}, id).Error
+// GetFlowRevisionByID 按 ID 获取流程配置修订
+func (s *gormStore) GetFlowRevisionByID(id uint) (*FlowRevision, error) {
+	var rev FlowRevision
+	err := s.db.First(&rev, id).Error
+	return &rev, err
+}
+
+// GetRevisionStages 解析修订的阶段配置
+func GetRevisionStages(rev *FlowRevision) ([]StageConfig, error) {
+	var stages []StageConfig
+	err := json.Unmarshal([]byte(rev.Stages), &stages)
+	return stages, err
+}
+
+// LoadVersionStages 获取版本执行的流程阶段：优先使用固定的修订，修订功能之前的版本使用流程配置
+// 紧急版本包含理由确认阶段并使用紧急时限，与工作流中执行的阶段一致
+func LoadVersionStages(version *VersionModel) ([]StageConfig, error) {
+	var stages []StageConfig
+	var err error
+	if version.FlowRevisionID == 0 {
+		stages, err = LoadFlowStages(version.FlowConfigID)
+	} else {
+		var rev *FlowRevision
+		if rev, err = store.GetFlowRevisionByID(version.FlowRevisionID); err == nil {
+			stages, err = GetRevisionStages(rev)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version.IsUrgent {
+		stages = urgentStages(stages)
+	}
+	return stages, nil
 }
 
 // GetFlowStages 解析流程阶段配置
@@ -203,83 +387,707 @@ func GetFlowStages(config *FlowConfig) ([]StageConfig, error) {
 	return stages, err
 }
 
+// LoadFlowStages 获取流程阶段，配置不存在时返回默认阶段
+func LoadFlowStages(flowConfigID uint) ([]StageConfig, error) {
+	config, err := store.GetFlowConfig(flowConfigID)
+	if err != nil {
+		return []StageConfig{
+			{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
+			{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
+			{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
+			{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
+			{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 5, Roles: prodFinalizeRoles, ApprovalMode: ApprovalModeAll},
+			{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 6},
+			{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 7},
+			{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 8},
+			{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 9},
+		}, nil
+	}
+	return GetFlowStages(config)
+}
+
 // ============================================================
 // 条目数据库操作
 // ============================================================
 
-func GetAllItems() ([]ItemModel, error) {
-	var items []ItemModel
-	err := db.Find(&items).Error
-	return items, err
+// ListItems 按条件分页查询条目
+func (s *gormStore) ListItems(q ItemQuery) (*ItemPage, error) {
+	query := s.db.Model(&ItemModel{})
+	if len(q.Statuses) > 0 {
+		query = query.Where("status IN ?", q.Statuses)
+	}
+	columns := map[string]string{
+		"type":           q.Type,
+		"developer":      q.Developer,
+		"tester":         q.Tester,
+		"item_owner":     q.ItemOwner,
+		"requirement_id": q.RequirementID,
+	}
+	for column, value := range columns {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	query = q.filter(query).Session(&gorm.Session{})
+
+	page := &ItemPage{}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := q.page(query).Find(&page.Items).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor = q.nextCursor(sortValue(q.Sort, last.ID, last.Name, last.CreatedAt, last.UpdatedAt), last.ID)
+	}
+	return page, nil
 }
 
-func GetItemByID(id string) (*ItemModel, error) {
+func (s *gormStore) GetItemByID(id string) (*ItemModel, error) {
 	var item ItemModel
-	err := db.First(&item, "id = ?", id).Error
+	err := s.db.First(&item, "id = ?", id).Error
 	return &item, err
 }
 
-func CreateItem(item *ItemModel) error {
-	return db.Create(item).Error
+// CreateItem 创建条目，未指定编号时在同一事务中分配编号
+func (s *gormStore) CreateItem(item *ItemModel) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if item.ID == "" {
+			id, err := NextID(tx, SequenceItem, time.Now())
+			if err != nil {
+				return err
+			}
+			item.ID = id
+		}
+		return tx.Create(item).Error
+	})
+}
+
+// UpdateItem 修改条目字段，revision 与当前版本号不一致时不修改
+// changes 为字段名到新值的映射，与当前值相同的字段不算修改；修改了当前状态不允许修改的字段时返回 ItemFieldError
+func (s *gormStore) UpdateItem(itemID string, revision int, changes map[string]interface{}) (*ItemModel, error) {
+	var item ItemModel
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockItemRevision(tx, &item, itemID, revision); err != nil {
+			return err
+		}
+		changed := changedItemFields(&item, changes)
+		if len(changed) == 0 {
+			return nil
+		}
+		if err := checkItemFields(&item, changed); err != nil {
+			return err
+		}
+		changed["revision"] = nextRevision
+		if err := tx.Model(&item).Updates(changed).Error; err != nil {
+			return err
+		}
+		return tx.First(&item, "id = ?", itemID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// CloseItem 关闭未加入版本的条目，revision 与当前版本号不一致时不关闭
+func (s *gormStore) CloseItem(itemID string, revision int, req ItemTransitionRequest) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var item ItemModel
+		if err := lockItemRevision(tx, &item, itemID, revision); err != nil {
+			return err
+		}
+		if item.VersionID != "" {
+			return &ItemOccupiedError{ItemID: itemID, VersionID: item.VersionID}
+		}
+		req.To = ItemStatusClosed
+		return transitionItem(tx, itemID, req, nil)
+	})
+}
+
+// DeleteItem 删除登记错误的条目及其状态变更历史，只能删除已登记且未加入版本的条目
+func (s *gormStore) DeleteItem(itemID string, revision int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var item ItemModel
+		if err := lockItemRevision(tx, &item, itemID, revision); err != nil {
+			return err
+		}
+		if item.VersionID != "" {
+			return &ItemOccupiedError{ItemID: itemID, VersionID: item.VersionID}
+		}
+		if item.Status != ItemStatusRegistered {
+			return fmt.Errorf("%w: 条目状态为 %s，只能删除%s的条目，其他条目请关闭", errItemNotDeletable, item.Status, ItemStatusRegistered)
+		}
+		if err := tx.Where("item_id = ?", itemID).Delete(&ItemTransitionModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&item).Error
+	})
 }
 
-func UpdateItem(item *ItemModel) error {
-	return db.Save(item).Error
+// ImportItems 按需求ID导入条目：已有条目修改单元格不为空的字段，没有的新建
+// 任一行失败或 dryRun 为 true 时回滚，返回的结果与实际导入时相同
+func (s *gormStore) ImportItems(rows []ItemImportRow, dryRun bool) ([]ItemImportResult, error) {
+	results := make([]ItemImportResult, len(rows))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		failed := false
+		for i, row := range rows {
+			result, err := importItemRow(tx, row)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+			failed = failed || result.Action == ImportError
+		}
+		if failed || dryRun {
+			return errImportRollback
+		}
+		return nil
+	})
+	if errors.Is(err, errImportRollback) {
+		err = nil
+	}
+	return results, err
+}
+
+var errImportRollback = errors.New("导入未提交")
+
+// importItemRow 在事务中导入一行，校验失败记录在结果中
+func importItemRow(tx *gorm.DB, row ItemImportRow) (ItemImportResult, error) {
+	result := ItemImportResult{Row: row.Row, RequirementID: row.RequirementID, Errors: row.Errors}
+	fail := func(format string, args ...interface{}) (ItemImportResult, error) {
+		result.Action = ImportError
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+		return result, nil
+	}
+	if len(row.Errors) > 0 {
+		result.Action = ImportError
+		return result, nil
+	}
+
+	var items []ItemModel
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("requirement_id = ?", row.RequirementID).Find(&items).Error; err != nil {
+		return result, err
+	}
+	switch len(items) {
+	case 0:
+		item := newImportedItem(row.Values)
+		if item.Name == "" {
+			return fail("名称不能为空")
+		}
+		id, err := NextID(tx, SequenceItem, time.Now())
+		if err != nil {
+			return result, err
+		}
+		item.ID = id
+		if err := tx.Create(item).Error; err != nil {
+			return result, err
+		}
+		result.ItemID, result.Action = item.ID, ImportCreate
+		return result, nil
+	case 1:
+	default:
+		ids := make([]string, len(items))
+		for i := range items {
+			ids[i] = items[i].ID
+		}
+		return fail("需求ID对应多个条目 %s，请手工修改", strings.Join(ids, "、"))
+	}
+
+	item := &items[0]
+	result.ItemID = item.ID
+	changed := changedItemFields(item, row.Values)
+	if len(changed) == 0 {
+		result.Action = ImportUnchanged
+		return result, nil
+	}
+	if err := checkItemFields(item, changed); err != nil {
+		return fail("%s", err.Error())
+	}
+	for field := range changed {
+		result.Fields = append(result.Fields, field)
+	}
+	sort.Strings(result.Fields)
+	changed["revision"] = nextRevision
+	if err := tx.Model(item).Updates(changed).Error; err != nil {
+		return result, err
+	}
+	result.Action = ImportUpdate
+	return result, nil
+}
+
+// lockItemRevision 锁定条目并检查乐观锁版本号
+func lockItemRevision(tx *gorm.DB, item *ItemModel, itemID string, revision int) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(item, "id = ?", itemID).Error; err != nil {
+		return err
+	}
+	if item.Revision != revision {
+		return &RevisionConflictError{ItemID: itemID, Expected: revision, Current: item.Revision}
+	}
+	return nil
+}
+
+// TransitionItems 按条目生命周期变更条目状态并记录历史，任一条目不允许变更时全部不变更
+func (s *gormStore) TransitionItems(req ItemTransitionRequest) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, itemID := range req.ItemIDs {
+			if err := transitionItem(tx, itemID, req, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// transitionItem 在事务中变更单个条目的状态，extra 为同时更新的其他字段
+func transitionItem(tx *gorm.DB, itemID string, req ItemTransitionRequest, extra map[string]interface{}) error {
+	var item ItemModel
+	if err := tx.First(&item, "id = ?", itemID).Error; err != nil {
+		return err
+	}
+	if err := checkItemTransition(item.ID, item.Status, req.To); err != nil {
+		return err
+	}
+
+	// Updates 会把新值写回 item，变更前的状态先记下来
+	from := item.Status
+	updates := map[string]interface{}{"status": req.To, "revision": nextRevision}
+	if req.To == ItemStatusClosed && from != req.To {
+		updates["close_reason"] = req.Reason
+	}
+	for column, value := range extra {
+		updates[column] = value
+	}
+	if err := tx.Model(&item).Updates(updates).Error; err != nil {
+		return err
+	}
+	if from == req.To {
+		return nil
+	}
+
+	return tx.Create(&ItemTransitionModel{
+		ItemID:     item.ID,
+		VersionID:  req.VersionID,
+		Stage:      req.Stage,
+		FromStatus: from,
+		ToStatus:   req.To,
+		Operator:   req.Operator,
+		Reason:     req.Reason,
+	}).Error
+}
+
+// GetItemTransitions 条目状态变更历史
+func (s *gormStore) GetItemTransitions(itemID string) ([]ItemTransitionModel, error) {
+	var transitions []ItemTransitionModel
+	err := s.db.Where("item_id = ?", itemID).Order("id").Find(&transitions).Error
+	return transitions, err
+}
+
+// UpdateItemTestResult 回写条目在指定测试阶段的测试结果
+func (s *gormStore) UpdateItemTestResult(itemID, stage, testResult string) error {
+	column := testResultColumn(stage)
+	if column == "" {
+		return fmt.Errorf("阶段 %s 不是测试阶段", stage)
+	}
+	return s.db.Model(&ItemModel{}).Where("id = ?", itemID).
+		Updates(map[string]interface{}{column: testResult, "revision": nextRevision}).Error
+}
+
+// testResultColumn 测试阶段对应的结果字段
+func testResultColumn(stage string) string {
+	switch stage {
+	case StageBTETest:
+		return "bte_result"
+	case StageGrayTest:
+		return "gray_result"
+	case StageProdTest:
+		return "prod_result"
+	}
+	return ""
 }
 
 // ============================================================
 // 版本数据库操作
 // ============================================================
 
-func GetAllVersions() ([]VersionModel, error) {
-	var versions []VersionModel
-	err := db.Order("created_at desc").Find(&versions).Error
-	return versions, err
+// ListVersions 按条件分页查询版本，紧急版本排在前面
+func (s *gormStore) ListVersions(q VersionQuery) (*VersionPage, error) {
+	query := s.db.Model(&VersionModel{})
+	if len(q.Statuses) > 0 {
+		query = query.Where("status IN ?", q.Statuses)
+	}
+	if q.CurrentStage != "" {
+		query = query.Where("current_stage = ?", q.CurrentStage)
+	}
+	if q.IsUrgent != nil {
+		query = query.Where("is_urgent = ?", *q.IsUrgent)
+	}
+	if q.VersionOwner != "" {
+		query = query.Where("version_owner = ?", q.VersionOwner)
+	}
+	if q.Tester != "" {
+		query = query.Where("(bte_tester = ? OR gray_tester = ? OR prod_tester = ?)", q.Tester, q.Tester, q.Tester)
+	}
+	query = q.filter(query).Session(&gorm.Session{})
+
+	page := &VersionPage{}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := q.urgentPage(query).Find(&page.Versions).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Versions) > q.Limit {
+		page.Versions = page.Versions[:q.Limit]
+		last := page.Versions[q.Limit-1]
+		page.NextCursor = q.nextUrgentCursor(last.IsUrgent, sortValue(q.Sort, last.ID, last.Name, last.CreatedAt, last.UpdatedAt), last.ID)
+	}
+	return page, nil
 }
 
-func GetVersionByID(id string) (*VersionModel, error) {
+func (s *gormStore) GetVersionByID(id string) (*VersionModel, error) {
 	var version VersionModel
-	err := db.First(&version, "id = ?", id).Error
+	err := s.db.First(&version, "id = ?", id).Error
 	return &version, err
 }
 
-func CreateVersion(version *VersionModel) error {
-	return db.Create(version).Error
+// SaveReleaseNote 保存版本发布说明，已存在时覆盖内容
+func (s *gormStore) SaveReleaseNote(note *ReleaseNoteModel) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "version_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"markdown", "html", "updated_at"}),
+	}).Create(note).Error
 }
 
-func UpdateVersion(version *VersionModel) error {
-	return db.Save(version).Error
+func (s *gormStore) GetReleaseNote(versionID string) (*ReleaseNoteModel, error) {
+	var note ReleaseNoteModel
+	err := s.db.First(&note, "version_id = ?", versionID).Error
+	return &note, err
 }
 
-// 生成条目ID
-func GenerateItemID() string {
-	var count int64
-	db.Model(&ItemModel{}).Count(&count)
-	return "ITEM-" + time.Now().Format("20060102") + "-" + padNumber(int(count)+1, 4)
+// ItemRejection 不能加入版本的条目及原因
+type ItemRejection struct {
+	ItemID string `json:"item_id"`
+	Reason string `json:"reason"`
 }
 
-// 生成版本ID
-func GenerateVersionID() string {
-	var count int64
-	db.Model(&VersionModel{}).Count(&count)
-	return "V" + time.Now().Format("200601") + "-" + padNumber(int(count)+1, 3)
+// CreateVersionWithItems 创建版本并独占条目，条目在版本结束或被挂起前不能加入其他版本
+// 有条目不符合条件时不创建版本，返回所有不符合条件的条目
+func (s *gormStore) CreateVersionWithItems(version *VersionModel, itemIDs []string) ([]ItemRejection, error) {
+	var rejections []ItemRejection
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		rejections = checkItemEligibility(tx, itemIDs)
+		if len(rejections) > 0 {
+			return nil
+		}
+		if version.ID == "" {
+			id, err := NextID(tx, SequenceVersion, time.Now())
+			if err != nil {
+				return err
+			}
+			version.ID = id
+		}
+		if err := tx.Create(version).Error; err != nil {
+			return err
+		}
+
+		// 条件更新保证并发创建的版本不会占用同一个条目
+		for _, itemID := range itemIDs {
+			result := tx.Model(&ItemModel{}).
+				Where("id = ? AND status = ? AND (version_id = '' OR version_id IS NULL)", itemID, ItemStatusAuditComplete).
+				Updates(map[string]interface{}{"version_id": version.ID, "revision": nextRevision})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				rejections = append(rejections, ItemRejection{ItemID: itemID, Reason: "已被其他版本占用"})
+			}
+		}
+		if len(rejections) > 0 {
+			return errItemsRejected
+		}
+		return nil
+	})
+	if errors.Is(err, errItemsRejected) {
+		err = nil
+	}
+	return rejections, err
+}
+
+var errItemsRejected = errors.New("条目不符合加入版本的条件")
+
+// checkItemEligibility 检查条目能否加入新版本：存在、未重复、已审核完成且未被其他版本占用
+func checkItemEligibility(tx *gorm.DB, itemIDs []string) []ItemRejection {
+	var rejections []ItemRejection
+	seen := make(map[string]bool, len(itemIDs))
+	for _, itemID := range itemIDs {
+		if seen[itemID] {
+			rejections = append(rejections, ItemRejection{ItemID: itemID, Reason: "条目重复"})
+			continue
+		}
+		seen[itemID] = true
+
+		var item ItemModel
+		switch {
+		case tx.First(&item, "id = ?", itemID).Error != nil:
+			rejections = append(rejections, ItemRejection{ItemID: itemID, Reason: "条目不存在"})
+		case item.VersionID != "":
+			rejections = append(rejections, ItemRejection{ItemID: itemID, Reason: "已被版本 " + item.VersionID + " 占用"})
+		case item.Status != ItemStatusAuditComplete:
+			rejections = append(rejections, ItemRejection{ItemID: itemID, Reason: "状态为 " + item.Status + "，需要" + ItemStatusAuditComplete})
+		}
+	}
+	return rejections
 }
 
-func padNumber(n, width int) string {
-	s := ""
-	for i := 0; i < width; i++ {
-		s = string('0'+byte(n%10)) + s
-		n /= 10
+// ReleaseVersionItems 释放版本占用的所有条目
+func ReleaseVersionItems(tx *gorm.DB, versionID string) error {
+	return tx.Model(&ItemModel{}).Where("version_id = ?", versionID).
+		Updates(map[string]interface{}{"version_id": "", "revision": nextRevision}).Error
+}
+
+// SetVersionWorkflowID 记录版本的 workflowID，只更新这一列，不覆盖流程写入的其他字段
+func (s *gormStore) SetVersionWorkflowID(versionID, workflowID string) error {
+	return s.db.Model(&VersionModel{}).Where("id = ?", versionID).Update("workflow_id", workflowID).Error
+}
+
+// UpdateVersionStatus 更新运行中版本的状态（暂停/恢复）
+func (s *gormStore) UpdateVersionStatus(versionID, status string) error {
+	return s.db.Model(&VersionModel{}).Where("id = ?", versionID).Update("status", status).Error
+}
+
+// RestoreVersionItems 恢复条目在版本开始时的状态和测试结果
+// 生命周期不允许恢复的条目（如已被手工关闭）保持当前状态，只恢复测试结果
+func (s *gormStore) RestoreVersionItems(versionID string, items []UpgradeItem) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, item := range items {
+			results := map[string]interface{}{
+				"bte_result":  item.BTEResult,
+				"gray_result": item.GrayResult,
+				"prod_result": item.ProdResult,
+			}
+			req := ItemTransitionRequest{VersionID: versionID, To: item.Status, Reason: "版本取消，恢复到版本开始时的状态"}
+			err := transitionItem(tx, item.ID, req, results)
+
+			var illegal *IllegalTransitionError
+			if errors.As(err, &illegal) {
+				logger.Warn("条目状态不能恢复，保持当前状态", zap.Error(err))
+				results["revision"] = nextRevision
+				err = tx.Model(&ItemModel{}).Where("id = ?", item.ID).Updates(results).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ============================================================
+// 阶段历史操作
+// ============================================================
+
+// StartStage 记录进入阶段，并更新版本当前阶段
+func (s *gormStore) StartStage(t StageTransition) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Activity 重试时不重复插入
+		var count int64
+		if err := tx.Model(&StageHistoryModel{}).
+			Where("version_id = ? AND stage = ? AND started_at = ?", t.VersionID, t.Stage, t.Timestamp).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			history := StageHistoryModel{
+				VersionID: t.VersionID,
+				Stage:     t.Stage,
+				StageName: t.StageName,
+				Status:    "in_progress",
+				Iteration: t.Iteration,
+				StartedAt: t.Timestamp,
+			}
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&VersionModel{}).Where("id = ?", t.VersionID).Update("current_stage", t.Stage).Error
+	})
+}
+
+// FinishStage 记录阶段结束
+func (s *gormStore) FinishStage(t StageTransition) error {
+	return s.db.Model(&StageHistoryModel{}).
+		Where("version_id = ? AND stage = ? AND completed_at IS NULL", t.VersionID, t.Stage).
+		Updates(map[string]interface{}{
+			"status":       t.Status,
+			"outcome":      t.Outcome,
+			"operator":     t.Operator,
+			"message":      t.Message,
+			"completed_at": t.Timestamp,
+		}).Error
+}
+
+func (s *gormStore) GetStageHistory(versionID string) ([]StageHistoryModel, error) {
+	var history []StageHistoryModel
+	err := s.db.Where("version_id = ?", versionID).Order("started_at, id").Find(&history).Error
+	return history, err
+}
+
+// FinishVersion 记录版本最终结果并释放版本占用的条目
+func (s *gormStore) FinishVersion(result UpgradeWorkflowResult, completedAt time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&VersionModel{}).Where("id = ?", result.VersionID).Updates(map[string]interface{}{
+			"status":        result.Status,
+			"current_stage": result.CurrentStage,
+			"message":       result.Message,
+			"completed_at":  completedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return ReleaseVersionItems(tx, result.VersionID)
+	})
+}
+
+// ============================================================
+// 审计记录操作
+// ============================================================
+
+// CreateAudit 写入审计记录，同一事件重复写入时忽略
+func (s *gormStore) CreateAudit(event AuditEvent) error {
+	audit := AuditModel{
+		VersionID:  event.VersionID,
+		Stage:      event.Stage,
+		EventType:  event.EventType,
+		ItemID:     event.ItemID,
+		Operator:   event.Operator,
+		Passed:     event.Passed,
+		Comment:    event.Comment,
+		OccurredAt: event.OccurredAt,
+	}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&audit).Error
+}
+
+// GetAudits 获取版本审计记录，stage 为空时返回全部阶段
+func (s *gormStore) GetAudits(versionID, stage string) ([]AuditModel, error) {
+	var audits []AuditModel
+	query := s.db.Where("version_id = ?", versionID)
+	if stage != "" {
+		query = query.Where("stage = ?", stage)
 	}
-	return s
+	err := query.Order("occurred_at, id").Find(&audits).Error
+	return audits, err
 }
 
-// InitDemoData 初始化演示数据
-func InitDemoData() {
+// ============================================================
+// 条目挂起操作
+// ============================================================
+
+// SuspendVersionItems 挂起版本中的条目并从版本中移除
+// reasons 为条目ID到挂起原因的映射；Activity 重试时已在该阶段挂起的条目跳过，不重复写入
+func (s *gormStore) SuspendVersionItems(versionID, stage string, reasons map[string]string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// 锁定版本，同一版本的挂起依次执行
+		var version VersionModel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&version, "id = ?", versionID).Error; err != nil {
+			return err
+		}
+
+		itemIDs := make([]string, 0, len(reasons))
+		for itemID := range reasons {
+			itemIDs = append(itemIDs, itemID)
+		}
+		sort.Strings(itemIDs)
+
+		var suspended []string
+		err := tx.Model(&SuspensionModel{}).
+			Where("version_id = ? AND stage = ? AND item_id IN ?", versionID, stage, itemIDs).
+			Pluck("item_id", &suspended).Error
+		if err != nil {
+			return err
+		}
+
+		var versionItemIDs []string
+		json.Unmarshal([]byte(version.ItemIDs), &versionItemIDs)
+
+		remaining := make([]string, 0, len(versionItemIDs))
+		for _, itemID := range versionItemIDs {
+			if _, ok := reasons[itemID]; !ok {
+				remaining = append(remaining, itemID)
+			}
+		}
+		remainingJSON, _ := json.Marshal(remaining)
+		if err := tx.Model(&version).Update("item_ids", string(remainingJSON)).Error; err != nil {
+			return err
+		}
+
+		for _, itemID := range itemIDs {
+			if containsKey(suspended, itemID) {
+				continue
+			}
+			reason := reasons[itemID]
+			// 挂起的条目从版本中释放
+			req := ItemTransitionRequest{VersionID: versionID, Stage: stage, To: ItemStatusSuspended, Reason: reason}
+			extra := map[string]interface{}{"close_reason": reason, "version_id": ""}
+			if err := transitionItem(tx, itemID, req, extra); err != nil {
+				return err
+			}
+
+			suspension := SuspensionModel{
+				VersionID: versionID,
+				ItemID:    itemID,
+				Stage:     stage,
+				Reason:    reason,
+			}
+			if err := tx.Create(&suspension).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gormStore) GetSuspensionsByVersion(versionID string) ([]SuspensionModel, error) {
+	var suspensions []SuspensionModel
+	err := s.db.Where("version_id = ?", versionID).Order("id").Find(&suspensions).Error
+	return suspensions, err
+}
+
+func (s *gormStore) GetSuspensionByID(id uint) (*SuspensionModel, error) {
+	var suspension SuspensionModel
+	err := s.db.First(&suspension, id).Error
+	return &suspension, err
+}
+
+func (s *gormStore) UpdateSuspension(suspension *SuspensionModel) error {
+	return s.db.Save(suspension).Error
+}
+
+// ============================================================
+// 通知偏好数据库操作
+// ============================================================
+
+func (s *gormStore) GetNotifyPreference(user string) (*NotifyPreference, error) {
+	var pref NotifyPreference
+	err := s.db.First(&pref, "username = ?", user).Error
+	return &pref, err
+}
+
+func (s *gormStore) SaveNotifyPreference(pref *NotifyPreference) error {
+	return s.db.Save(pref).Error
+}
+
+// InitDemoData 初始化演示数据，已有条目时不处理
+func (s *gormStore) InitDemoData() error {
 	var count int64
-	db.Model(&ItemModel{}).Count(&count)
+	if err := s.db.Model(&ItemModel{}).Count(&count).Error; err != nil {
+		return err
+	}
 	if count > 0 {
-		return
+		return nil
 	}
 
 	demoItems := []ItemModel{
@@ -318,8 +1126,11 @@ func InitDemoData() {
 	}
 
 	for _, item := range demoItems {
-		db.Create(&item)
+		if err := s.db.Create(&item).Error; err != nil {
+			return err
+		}
 	}
 
 	logger.Info("演示数据初始化完成")
+	return nil
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 审批超时提醒与升级
+// 提醒：在超时时间的指定百分比处通知尚未表态的审批人
+// 升级：首次超时后交由版本负责人处理并延长一次截止时间，再次超时则阶段失败
+// ============================================================
+
+// stageDeadline 审批阶段的截止时间和提醒
+type stageDeadline struct {
+	ctx        workflow.Context
+	state      *WorkflowState
+	stage      StageConfig
+	version    UpgradeVersion
+	recipients func() []string // 提醒对象，提醒时计算
+
+	timer       workflow.Future
+	escalatedTo string // 已升级到的人员，为空表示未升级
+	cancel      workflow.CancelFunc
+}
+
+func newStageDeadline(ctx workflow.Context, state *WorkflowState, stage StageConfig, version UpgradeVersion, recipients func() []string) *stageDeadline {
+	return &stageDeadline{
+		ctx:        ctx,
+		state:      state,
+		stage:      stage,
+		version:    version,
+		recipients: recipients,
+	}
+}
+
+// start 从当前时间开始计时，取消之前的计时器和提醒；版本暂停期间不计时
+func (d *stageDeadline) start(window time.Duration) {
+	d.stop()
+	timerCtx, cancel := workflow.WithCancel(d.ctx)
+	d.cancel = cancel
+	d.timer = newPausableTimer(timerCtx, d.state, window)
+	d.state.setDeadline(d.stage.Key, workflow.Now(d.ctx).Add(window), d.escalatedTo)
+
+	reminders := reminderOffsets(d.stage.Reminders, window)
+	if len(reminders) == 0 {
+		return
+	}
+	workflow.Go(timerCtx, func(ctx workflow.Context) {
+		var elapsed time.Duration
+		for _, offset := range reminders {
+			if err := pausableSleep(ctx, d.state, offset-elapsed); err != nil {
+				return
+			}
+			elapsed = offset
+
+			recipients := d.recipients()
+			if len(recipients) == 0 {
+				continue
+			}
+			logger.Info("发送超时提醒",
+				zap.String("stage", d.stage.Key),
+				zap.Strings("recipients", recipients))
+			notify(ctx, stageNotification(d.version, d.stage, NotifyEventRemind, recipients,
+				fmt.Sprintf("提醒：版本 %s【%s】阶段将在 %s 后超时，请尽快处理",
+					d.version.Name, d.stage.Name, window-offset),
+				map[string]string{"remaining": (window - offset).String()}))
+		}
+	})
+}
+
+// stop 取消计时器和未发送的提醒
+func (d *stageDeadline) stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// reminderOffsets 提醒时间点（相对计时开始），按时间排序，忽略 (0, 100) 之外的百分比
+func reminderOffsets(percents []int, window time.Duration) []time.Duration {
+	var offsets []time.Duration
+	for _, percent := range percents {
+		if percent > 0 && percent < 100 {
+			offsets = append(offsets, time.Duration(int64(window)*int64(percent)/100))
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// extendWindow 升级后延长的时长
+func extendWindow(stage StageConfig) time.Duration {
+	if stage.ExtendHours > 0 {
+		return time.Duration(stage.ExtendHours) * time.Hour
+	}
+	return time.Duration(stage.Timeout) * time.Hour
+}
+
+// canEscalate 超时后能否升级：配置了升级策略、尚未升级过且版本有负责人
+func canEscalate(stage StageConfig, version UpgradeVersion, d *stageDeadline) bool {
+	return stage.Escalation == EscalationEscalate && d.escalatedTo == "" && version.VersionOwner != ""
+}
+
+// escalateStage 升级到版本负责人：授予审批权限、延长截止时间并通知
+func escalateStage(ctx workflow.Context, state *WorkflowState, stage StageConfig, version UpgradeVersion, cs *countersign, d *stageDeadline) error {
+	owner := version.VersionOwner
+	window := extendWindow(stage)
+
+	recordAudit(ctx, state, AuditEvent{
+		Stage:     stage.Key,
+		EventType: AuditEscalate,
+		Operator:  owner,
+		Comment:   fmt.Sprintf("审批超时（%s），升级到版本负责人 %s，截止时间延长 %s", cs.summary(), owner, window),
+	})
+	req := EscalateStageRequest{VersionID: version.ID, Stage: stage.Key, To: owner}
+	if err := workflow.ExecuteActivity(persistContext(ctx), EscalateStageActivity, req).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	cs.escalate(owner)
+	d.escalatedTo = owner
+	d.start(window)
+	state.updateCountersign(stage.Key, cs)
+
+	notify(ctx, stageNotification(version, stage, NotifyEventEscalate, []string{owner},
+		fmt.Sprintf("版本 %s【%s】阶段审批超时，已升级到您处理，请在 %s 内完成",
+			version.Name, stage.Name, window),
+		map[string]string{"extend": window.String()}))
+	logger.Info("审批超时升级",
+		zap.String("stage", stage.Key),
+		zap.String("escalatedTo", owner),
+		zap.Duration("extend", window))
+	return nil
+}
+
+// validateTimeoutPolicy 校验提醒和升级配置
+func validateTimeoutPolicy(v *flowValidator, field string, stage StageConfig) {
+	for i, percent := range stage.Reminders {
+		if percent <= 0 || percent >= 100 {
+			v.add(fmt.Sprintf("%s.reminders[%d]", field, i), "提醒时间点 %d%% 必须在 1 到 99 之间", percent)
+		}
+	}
+	switch stage.Escalation {
+	case "", EscalationFail, EscalationEscalate:
+	default:
+		v.add(field+".escalation", "超时升级策略 %s 无效", stage.Escalation)
+	}
+	if stage.ExtendHours < 0 {
+		v.add(field+".extend_hours", "延长时间不能为负数")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================
+// 阶段依赖图
+// 阶段未声明 depends_on 时依赖前一个启用的阶段，因此未声明依赖的
+// 配置仍按顺序执行；声明依赖后可形成并行分支和汇合点
+// ============================================================
+
+// stageGraph 阶段依赖图，只包含启用的阶段
+type stageGraph struct {
+	order  []string               // 启用阶段的配置顺序
+	stages map[string]StageConfig // 阶段 -> 配置
+	deps   map[string][]string    // 阶段 -> 依赖的阶段
+}
+
+// buildStageGraph 构建依赖图并检查循环依赖
+func buildStageGraph(stages []StageConfig) (*stageGraph, error) {
+	all := make(map[string]StageConfig, len(stages))
+	for _, stage := range stages {
+		all[stage.Key] = stage
+	}
+
+	// 声明的依赖，未声明时为前一个阶段（不论是否启用）
+	declared := make(map[string][]string, len(stages))
+	for i, stage := range stages {
+		if len(stage.DependsOn) > 0 {
+			for _, dep := range stage.DependsOn {
+				if _, ok := all[dep]; !ok {
+					return nil, fmt.Errorf("阶段 %s 依赖的阶段 %s 不存在", stage.Key, dep)
+				}
+			}
+			declared[stage.Key] = stage.DependsOn
+		} else if i > 0 {
+			declared[stage.Key] = []string{stages[i-1].Key}
+		}
+	}
+
+	g := &stageGraph{
+		stages: make(map[string]StageConfig),
+		deps:   make(map[string][]string),
+	}
+	for _, stage := range stages {
+		if !stage.Enabled {
+			continue
+		}
+		deps, err := resolveEnabledDeps(stage.Key, declared, all, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		g.order = append(g.order, stage.Key)
+		g.stages[stage.Key] = stage
+		g.deps[stage.Key] = deps
+	}
+
+	if cycle := g.findCycle(); cycle != "" {
+		return nil, fmt.Errorf("阶段存在循环依赖: %s", cycle)
+	}
+	return g, nil
+}
+
+// resolveEnabledDeps 解析阶段依赖的启用阶段，禁用的阶段由其自身依赖代替
+func resolveEnabledDeps(key string, declared map[string][]string, all map[string]StageConfig, visiting map[string]bool) ([]string, error) {
+	if visiting[key] {
+		return nil, fmt.Errorf("阶段存在循环依赖: %s", key)
+	}
+	visiting[key] = true
+	defer delete(visiting, key)
+
+	var deps []string
+	seen := make(map[string]bool)
+	for _, dep := range declared[key] {
+		var resolved []string
+		if all[dep].Enabled {
+			resolved = []string{dep}
+		} else {
+			var err error
+			resolved, err = resolveEnabledDeps(dep, declared, all, visiting)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range resolved {
+			if !seen[r] {
+				seen[r] = true
+				deps = append(deps, r)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// findCycle 返回循环依赖路径，无循环时返回空
+func (g *stageGraph) findCycle() string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int, len(g.order))
+	var path []string
+
+	var visit func(key string) string
+	visit = func(key string) string {
+		switch marks[key] {
+		case visiting:
+			for i, k := range path {
+				if k == key {
+					return strings.Join(append(path[i:], key), " -> ")
+				}
+			}
+		case visited:
+			return ""
+		}
+		marks[key] = visiting
+		path = append(path, key)
+		for _, dep := range g.deps[key] {
+			if cycle := visit(dep); cycle != "" {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		marks[key] = visited
+		return ""
+	}
+
+	for _, key := range g.order {
+		if cycle := visit(key); cycle != "" {
+			return cycle
+		}
+	}
+	return ""
+}
+
+// ready 依赖都已完成的阶段
+func (g *stageGraph) ready(key string, done map[string]bool) bool {
+	for _, dep := range g.deps[key] {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// isAncestor 判断 ancestor 是否为 key 的（间接）依赖
+func (g *stageGraph) isAncestor(ancestor, key string) bool {
+	for _, dep := range g.deps[key] {
+		if dep == ancestor || g.isAncestor(ancestor, dep) {
+			return true
+		}
+	}
+	return false
+}
+
+// descendants 阶段自身及所有（间接）依赖它的阶段，按配置顺序
+func (g *stageGraph) descendants(key string) []string {
+	var result []string
+	for _, k := range g.order {
+		if k == key || g.isAncestor(key, k) {
+			result = append(result, k)
+		}
+	}
+	return result
+}
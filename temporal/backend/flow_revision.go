@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// ============================================================
+// 流程配置修订对比
+// ============================================================
+
+// FieldChange 字段变更
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// StageChange 阶段配置变更
+type StageChange struct {
+	Key     string        `json:"key"`
+	Name    string        `json:"name"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// FlowRevisionDiff 两个修订之间的差异
+type FlowRevisionDiff struct {
+	FlowConfigID uint          `json:"flow_config_id"`
+	From         int           `json:"from"`
+	To           int           `json:"to"`
+	Fields       []FieldChange `json:"fields"`        // 名称、描述变更
+	Added        []StageConfig `json:"added"`         // 新增的阶段
+	Removed      []StageConfig `json:"removed"`       // 删除的阶段
+	Changed      []StageChange `json:"changed"`       // 配置变化的阶段
+	OrderChanged bool          `json:"order_changed"` // 阶段顺序是否变化
+}
+
+// DiffFlowRevisions 对比两个修订
+func DiffFlowRevisions(from, to *FlowRevision) (*FlowRevisionDiff, error) {
+	fromStages, err := GetRevisionStages(from)
+	if err != nil {
+		return nil, err
+	}
+	toStages, err := GetRevisionStages(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &FlowRevisionDiff{
+		FlowConfigID: to.FlowConfigID,
+		From:         from.Revision,
+		To:           to.Revision,
+	}
+	if from.Name != to.Name {
+		diff.Fields = append(diff.Fields, FieldChange{Field: "name", Old: from.Name, New: to.Name})
+	}
+	if from.Description != to.Description {
+		diff.Fields = append(diff.Fields, FieldChange{Field: "description", Old: from.Description, New: to.Description})
+	}
+
+	oldByKey := make(map[string]StageConfig, len(fromStages))
+	for _, stage := range fromStages {
+		oldByKey[stage.Key] = stage
+	}
+	newByKey := make(map[string]StageConfig, len(toStages))
+	for _, stage := range toStages {
+		newByKey[stage.Key] = stage
+	}
+
+	var oldOrder, newOrder []string
+	for _, stage := range fromStages {
+		if _, ok := newByKey[stage.Key]; !ok {
+			diff.Removed = append(diff.Removed, stage)
+			continue
+		}
+		oldOrder = append(oldOrder, stage.Key)
+	}
+	for _, stage := range toStages {
+		old, ok := oldByKey[stage.Key]
+		if !ok {
+			diff.Added = append(diff.Added, stage)
+			continue
+		}
+		newOrder = append(newOrder, stage.Key)
+		if changes := diffStage(old, stage); len(changes) > 0 {
+			diff.Changed = append(diff.Changed, StageChange{Key: stage.Key, Name: stage.Name, Changes: changes})
+		}
+	}
+	diff.OrderChanged = !reflect.DeepEqual(oldOrder, newOrder)
+	return diff, nil
+}
+
+// diffStage 按 JSON 字段对比阶段配置，字段按名称排序
+func diffStage(from, to StageConfig) []FieldChange {
+	oldFields := stageFields(from)
+	newFields := stageFields(to)
+
+	names := make(map[string]bool)
+	for name := range oldFields {
+		names[name] = true
+	}
+	for name := range newFields {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []FieldChange
+	for _, name := range sorted {
+		if name == "order" {
+			continue
+		}
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			changes = append(changes, FieldChange{Field: name, Old: oldFields[name], New: newFields[name]})
+		}
+	}
+	return changes
+}
+
+func stageFields(stage StageConfig) map[string]interface{} {
+	data, _ := json.Marshal(stage)
+	fields := make(map[string]interface{})
+	json.Unmarshal(data, &fields)
+	return fields
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 条目导入导出
+// 厂家的需求清单以 CSV（UTF-8）或 XLSX 导入，按需求ID幂等地新建或修改条目：
+//   表头按列名识别，可以用 mapping 指定 字段 -> 表头；需求ID和名称列必须有
+//   需求ID已有条目时修改条目，空单元格不修改；修改受条目状态限制，见 item_state.go
+//   任一行校验失败时全部不导入，返回每一行的结果；dry_run 只校验不写入
+// 导出的表头与导入相同，导出的文件修改后可以直接导入
+// ============================================================
+
+// 导入导出格式
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// 导入限制
+const (
+	maxImportSize = 10 << 20 // 文件大小
+	maxImportRows = 5000     // 数据行数，不含表头
+)
+
+// 导入结果
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportError     = "error"
+)
+
+// itemColumn 导入导出的条目列
+type itemColumn struct {
+	field   string   // 字段名，与 UpdateItem 的字段一致
+	label   string   // 导出的表头
+	aliases []string // 导入时还可以识别的表头
+	export  func(item *ItemModel) string
+}
+
+// itemColumns 导出的列，按表格中的顺序
+var itemColumns = []itemColumn{
+	{"id", "条目ID", nil, func(m *ItemModel) string { return m.ID }},
+	{"requirement_id", "需求ID", []string{"关联需求ID", "工单号"}, func(m *ItemModel) string { return m.RequirementID }},
+	{"name", "名称", []string{"条目名称", "需求名称"}, func(m *ItemModel) string { return m.Name }},
+	{"type", "类型", nil, func(m *ItemModel) string { return m.Type }},
+	{"developer", "开发人员", nil, func(m *ItemModel) string { return m.Developer }},
+	{"tester", "测试人员", nil, func(m *ItemModel) string { return m.Tester }},
+	{"item_owner", "条目负责人", nil, func(m *ItemModel) string { return m.ItemOwner }},
+	{"has_script", "涉及脚本", nil, func(m *ItemModel) string { return formatBool(m.HasScript) }},
+	{"has_cache", "涉及缓存", nil, func(m *ItemModel) string { return formatBool(m.HasCache) }},
+	{"has_restart", "需要重启", nil, func(m *ItemModel) string { return formatBool(m.HasRestart) }},
+	{"test_cases", "测试用例", nil, func(m *ItemModel) string { return strings.Join(m.TestCaseList(), "\n") }},
+	{"status", "状态", nil, func(m *ItemModel) string { return m.Status }},
+	{"bte_result", "BTE结果", nil, func(m *ItemModel) string { return m.BTEResult }},
+	{"gray_result", "灰度结果", nil, func(m *ItemModel) string { return m.GrayResult }},
+	{"prod_result", "生产结果", nil, func(m *ItemModel) string { return m.ProdResult }},
+	{"close_reason", "关闭原因", nil, func(m *ItemModel) string { return m.CloseReason }},
+	{"version_id", "所属版本", nil, func(m *ItemModel) string { return m.VersionID }},
+	{"created_at", "创建时间", nil, func(m *ItemModel) string { return m.CreatedAt.Format("2006-01-02 15:04:05") }},
+}
+
+// importFields 可以导入的字段，其余列导入时忽略
+var importFields = []string{"requirement_id", "name", "type", "developer", "tester", "item_owner", "has_script", "has_cache", "has_restart", "test_cases"}
+
+// itemTypes 条目类型
+var itemTypes = []string{"需求", "BUG", "优化"}
+
+// ItemImportRow 解析后的一行，Values 为单元格不为空的字段
+type ItemImportRow struct {
+	Row           int // 表格中的行号，表头为第 1 行
+	RequirementID string
+	Values        map[string]interface{}
+	Errors        []string
+}
+
+// ItemImportResult 一行的导入结果
+type ItemImportResult struct {
+	Row           int      `json:"row"`
+	RequirementID string   `json:"requirement_id"`
+	ItemID        string   `json:"item_id,omitempty"`
+	Action        string   `json:"action"`           // create/update/unchanged/error
+	Fields        []string `json:"fields,omitempty"` // 修改的字段
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// ============================================================
+// 导入
+// ============================================================
+
+// importItemsHandler 导入条目，表单字段：file、mapping（JSON）、dry_run；操作人取认证的用户
+func importItemsHandler(c *gin.Context) {
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+
+	var mapping map[string]string
+	if v := c.PostForm("mapping"); v != "" {
+		if err := json.Unmarshal([]byte(v), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping 应为 字段 -> 表头 的 JSON 对象"})
+			return
+		}
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传 CSV 或 XLSX 文件"})
+		return
+	}
+	if header.Size > maxImportSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("文件不能超过 %dMB", maxImportSize>>20)})
+		return
+	}
+	format := c.PostForm("format")
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	table, err := readTable(file, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := parseImportRows(table, mapping)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := store.ImportItems(rows, dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Action]++
+	}
+	response := gin.H{
+		"dry_run":   dryRun,
+		"imported":  !dryRun && counts[ImportError] == 0,
+		"total":     len(results),
+		"created":   counts[ImportCreate],
+		"updated":   counts[ImportUpdate],
+		"unchanged": counts[ImportUnchanged],
+		"failed":    counts[ImportError],
+		"rows":      results,
+	}
+	if counts[ImportError] > 0 {
+		response["error"] = fmt.Sprintf("%d 行校验失败，全部未导入", counts[ImportError])
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	logger.Info("条目已导入",
+		zap.String("file", header.Filename),
+		zap.String("operator", operator),
+		zap.Bool("dryRun", dryRun),
+		zap.Int("created", counts[ImportCreate]),
+		zap.Int("updated", counts[ImportUpdate]))
+	c.JSON(http.StatusOK, response)
+}
+
+// readTable 读取 CSV 或 XLSX 的所有行，XLSX 读取第一个工作表
+func readTable(r io.Reader, format string) ([][]string, error) {
+	switch format {
+	case FormatCSV:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		reader.FieldsPerRecord = -1
+		table, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("CSV 格式错误: %w", err)
+		}
+		return table, nil
+	case FormatXLSX:
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("XLSX 格式错误: %w", err)
+		}
+		defer f.Close()
+		return f.GetRows(f.GetSheetName(0))
+	}
+	return nil, fmt.Errorf("不支持的格式 %s，可选 csv/xlsx", format)
+}
+
+// importColumns 按表头和 mapping 确定各字段所在的列
+func importColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		if name = strings.TrimSpace(name); name != "" {
+			if _, ok := index[name]; !ok {
+				index[name] = i
+			}
+		}
+	}
+
+	columns := make(map[string]int)
+	for field, name := range mapping {
+		if !containsKey(importFields, field) {
+			return nil, fmt.Errorf("mapping 字段 %s 不能导入，可选 %s", field, strings.Join(importFields, "/"))
+		}
+		i, ok := index[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("mapping 中 %s 对应的表头 %s 不存在", field, name)
+		}
+		columns[field] = i
+	}
+	for _, column := range itemColumns {
+		if _, ok := columns[column.field]; ok || !containsKey(importFields, column.field) {
+			continue
+		}
+		for _, name := range append([]string{column.label, column.field}, column.aliases...) {
+			if i, ok := index[name]; ok {
+				columns[column.field] = i
+				break
+			}
+		}
+	}
+
+	for _, field := range []string{"requirement_id", "name"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("缺少 %s 列，请检查表头或指定 mapping", itemColumnLabel(field))
+		}
+	}
+	return columns, nil
+}
+
+// parseImportRows 解析并校验数据行，跳过空行
+func parseImportRows(table [][]string, mapping map[string]string) ([]ItemImportRow, error) {
+	if len(table) == 0 {
+		return nil, errors.New("文件为空")
+	}
+	columns, err := importColumns(table[0], mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []ItemImportRow
+	seen := make(map[string]int)
+	for i, record := range table[1:] {
+		row := ItemImportRow{Row: i + 2, Values: make(map[string]interface{})}
+		for field, col := range columns {
+			if col < len(record) {
+				if value := strings.TrimSpace(record[col]); value != "" {
+					row.parseValue(field, value)
+				}
+			}
+		}
+		if len(row.Values) == 0 && len(row.Errors) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("数据行不能超过 %d 行", maxImportRows)
+		}
+
+		row.RequirementID, _ = row.Values["requirement_id"].(string)
+		if row.RequirementID == "" {
+			row.Errors = append(row.Errors, "需求ID不能为空")
+		} else if first, ok := seen[row.RequirementID]; ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("需求ID与第 %d 行重复", first))
+		} else {
+			seen[row.RequirementID] = row.Row
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("没有数据行")
+	}
+	return rows, nil
+}
+
+// parseValue 解析单元格并记录错误
+func (row *ItemImportRow) parseValue(field, value string) {
+	switch field {
+	case "type":
+		if !containsKey(itemTypes, value) {
+			row.Errors = append(row.Errors, fmt.Sprintf("类型 %s 无效，可选 %s", value, strings.Join(itemTypes, "/")))
+			return
+		}
+	case "has_script", "has_cache", "has_restart":
+		b, err := parseBool(value)
+		if err != nil {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s %s 无效，应为 是/否", itemColumnLabel(field), value))
+			return
+		}
+		row.Values[field] = b
+		return
+	case "test_cases":
+		cases := strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ';' || r == '；' })
+		var trimmed []string
+		for _, c := range cases {
+			if c = strings.TrimSpace(c); c != "" {
+				trimmed = append(trimmed, c)
+			}
+		}
+		data, _ := json.Marshal(trimmed)
+		row.Values[field] = string(data)
+		return
+	}
+	row.Values[field] = value
+}
+
+// newImportedItem 按导入的字段新建条目，其余字段与手工创建的条目相同
+func newImportedItem(values map[string]interface{}) *ItemModel {
+	item := &ItemModel{
+		Status:     ItemStatusRegistered,
+		BTEResult:  TestResultPending,
+		GrayResult: TestResultPending,
+		ProdResult: TestResultPending,
+	}
+	str := func(field string) string { s, _ := values[field].(string); return s }
+	flag := func(field string) bool { b, _ := values[field].(bool); return b }
+	item.RequirementID = str("requirement_id")
+	item.Name = str("name")
+	item.Type = str("type")
+	item.Developer = str("developer")
+	item.Tester = str("tester")
+	item.ItemOwner = str("item_owner")
+	item.TestCases = str("test_cases")
+	item.HasScript = flag("has_script")
+	item.HasCache = flag("has_cache")
+	item.HasRestart = flag("has_restart")
+	return item
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "是", "y", "yes", "true", "1":
+		return true, nil
+	case "否", "n", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("无效的布尔值 %s", v)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "是"
+	}
+	return "否"
+}
+
+func itemColumnLabel(field string) string {
+	for _, column := range itemColumns {
+		if column.field == field {
+			return column.label
+		}
+	}
+	return field
+}
+
+// ============================================================
+// 导出
+// ============================================================
+
+// exportItemsHandler 按列表的筛选条件导出条目，不分页
+func exportItemsHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", FormatCSV)
+	q, err := parseItemQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.Limit = maxPageLimit
+
+	var items []ItemModel
+	for {
+		page, err := store.ListItems(q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor, _ = decodeCursor(page.NextCursor)
+	}
+	writeItemTable(c, items, format, "items-"+time.Now().Format("20060102"))
+}
+
+// exportVersionItemsHandler 导出版本的条目清单
+func exportVersionItemsHandler(c *gin.Context) {
+	versionID := c.Param("versionId")
+	version, err := store.GetVersionByID(versionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return
+	}
+
+	var itemIDs []string
+	json.Unmarshal([]byte(version.ItemIDs), &itemIDs)
+	items := make([]ItemModel, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		if item, err := store.GetItemByID(itemID); err == nil {
+			items = append(items, *item)
+		}
+	}
+	writeItemTable(c, items, c.DefaultQuery("format", FormatCSV), version.ID+"-items")
+}
+
+// writeItemTable 以附件返回条目表格
+func writeItemTable(c *gin.Context, items []ItemModel, format, name string) {
+	header := make([]string, len(itemColumns))
+	for i, column := range itemColumns {
+		header[i] = column.label
+	}
+	records := [][]string{header}
+	for i := range items {
+		record := make([]string, len(itemColumns))
+		for j, column := range itemColumns {
+			record[j] = column.export(&items[i])
+		}
+		records = append(records, record)
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	switch format {
+	case FormatCSV:
+		// 带 BOM，Excel 打开时按 UTF-8 识别中文
+		buf.WriteString("\xef\xbb\xbf")
+		w := csv.NewWriter(&buf)
+		w.WriteAll(records)
+		contentType = "text/csv; charset=utf-8"
+	case FormatXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		for i, record := range records {
+			cell, _ := excelize.CoordinatesToCellName(1, i+1)
+			row := make([]interface{}, len(record))
+			for j, v := range record {
+				row[j] = v
+			}
+			if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := f.Write(&buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的格式 " + format + "，可选 csv/xlsx"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ============================================================
+// 条目生命周期
+//   已登记 → 测试完成 → 审核完成 → BTE已定版 → 灰度已定版 → 生产已定版 → 已关闭
+// 定版状态由升级流程在对应阶段完成时推进，紧急版本跳过灰度；
+// 测试不通过的条目可以挂起，挂起后重新审核完成才能加入新版本；
+// 退回到前面的定版阶段、取消版本时条目回到之前的状态
+// ============================================================
+
+// itemTransitions 允许的状态变更：当前状态 -> 目标状态
+var itemTransitions = map[string][]string{
+	ItemStatusRegistered:    {ItemStatusTestComplete, ItemStatusClosed},
+	ItemStatusTestComplete:  {ItemStatusAuditComplete, ItemStatusRegistered, ItemStatusClosed},
+	ItemStatusAuditComplete: {ItemStatusBTEFinalized, ItemStatusTestComplete, ItemStatusSuspended, ItemStatusClosed},
+	ItemStatusBTEFinalized:  {ItemStatusGrayFinalized, ItemStatusProdFinalized, ItemStatusSuspended, ItemStatusAuditComplete},
+	ItemStatusGrayFinalized: {ItemStatusProdFinalized, ItemStatusBTEFinalized, ItemStatusSuspended, ItemStatusAuditComplete},
+	ItemStatusProdFinalized: {ItemStatusClosed, ItemStatusGrayFinalized, ItemStatusBTEFinalized, ItemStatusSuspended, ItemStatusAuditComplete},
+	ItemStatusSuspended:     {ItemStatusAuditComplete, ItemStatusClosed},
+	ItemStatusClosed:        {},
+}
+
+// manualItemStatuses 可以通过接口手工变更到的状态，定版和挂起由升级流程推进
+var manualItemStatuses = []string{ItemStatusRegistered, ItemStatusTestComplete, ItemStatusAuditComplete, ItemStatusClosed}
+
+// stageItemStatus 阶段完成后条目进入的状态
+var stageItemStatus = map[string]string{
+	StageBTEFinalize:  ItemStatusBTEFinalized,
+	StageGrayFinalize: ItemStatusGrayFinalized,
+	StageProdFinalize: ItemStatusProdFinalized,
+	StageCloseConfirm: ItemStatusClosed,
+}
+
+// IllegalTransitionError 不允许的条目状态变更
+type IllegalTransitionError struct {
+	ItemID string
+	From   string
+	To     string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("条目 %s 不能从 %s 变更为 %s", e.ItemID, e.From, e.To)
+}
+
+// checkItemTransition 校验条目状态变更，状态不变时不算变更
+func checkItemTransition(itemID, from, to string) error {
+	if from == to {
+		return nil
+	}
+	if _, ok := itemTransitions[to]; !ok {
+		return fmt.Errorf("未知的条目状态 %s", to)
+	}
+	if !containsKey(itemTransitions[from], to) {
+		return &IllegalTransitionError{ItemID: itemID, From: from, To: to}
+	}
+	return nil
+}
+
+// ============================================================
+// 条目修改
+// 条目按状态限制可以修改的字段：
+//   未加入版本时可以修改全部基本信息
+//   加入版本或已定版后升级内容不能修改，只能修改人员和测试用例
+//   生产已定版后只能修正测试结果，关闭后不能修改
+// 修改、关闭和删除需要带上读取时的 revision，期间条目被修改过时拒绝，避免覆盖他人的修改
+// ============================================================
+
+// 条目可以修改的字段
+var (
+	itemContentFields = []string{"name", "type", "requirement_id", "has_script", "has_cache", "has_restart"}
+	itemPeopleFields  = []string{"developer", "tester", "item_owner", "test_cases"}
+	itemResultFields  = []string{"bte_result", "gray_result", "prod_result"}
+)
+
+// editableItemFields 条目当前状态下可以修改的字段
+func editableItemFields(item *ItemModel) []string {
+	switch {
+	case item.Status == ItemStatusClosed:
+		return nil
+	case item.Status == ItemStatusProdFinalized:
+		return itemResultFields
+	case item.VersionID != "" || item.Status == ItemStatusBTEFinalized || item.Status == ItemStatusGrayFinalized:
+		return itemPeopleFields
+	}
+	return append(append([]string{}, itemContentFields...), itemPeopleFields...)
+}
+
+// itemFieldValues 条目可修改字段的当前值
+func itemFieldValues(item *ItemModel) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           item.Name,
+		"type":           item.Type,
+		"requirement_id": item.RequirementID,
+		"has_script":     item.HasScript,
+		"has_cache":      item.HasCache,
+		"has_restart":    item.HasRestart,
+		"developer":      item.Developer,
+		"tester":         item.Tester,
+		"item_owner":     item.ItemOwner,
+		"test_cases":     item.TestCases,
+		"bte_result":     item.BTEResult,
+		"gray_result":    item.GrayResult,
+		"prod_result":    item.ProdResult,
+	}
+}
+
+// changedItemFields 与当前值不同的字段
+func changedItemFields(item *ItemModel, changes map[string]interface{}) map[string]interface{} {
+	current := itemFieldValues(item)
+	changed := make(map[string]interface{}, len(changes))
+	for field, value := range changes {
+		if current[field] != value {
+			changed[field] = value
+		}
+	}
+	return changed
+}
+
+// checkItemFields 校验条目当前状态下能否修改这些字段
+func checkItemFields(item *ItemModel, changed map[string]interface{}) error {
+	editable := editableItemFields(item)
+	var denied []string
+	for field := range changed {
+		if !containsKey(editable, field) {
+			denied = append(denied, field)
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return &ItemFieldError{ItemID: item.ID, Status: item.Status, Fields: denied}
+	}
+	return nil
+}
+
+// ItemFieldError 条目当前状态不允许修改的字段
+type ItemFieldError struct {
+	ItemID string
+	Status string
+	Fields []string
+}
+
+func (e *ItemFieldError) Error() string {
+	return fmt.Sprintf("条目 %s 状态为 %s，不能修改 %s", e.ItemID, e.Status, strings.Join(e.Fields, "、"))
+}
+
+// ItemOccupiedError 条目已被版本占用，版本结束前不能手工变更
+type ItemOccupiedError struct {
+	ItemID    string
+	VersionID string
+}
+
+func (e *ItemOccupiedError) Error() string {
+	return fmt.Sprintf("条目 %s 已被版本 %s 占用，版本结束前不能手工变更", e.ItemID, e.VersionID)
+}
+
+// RevisionConflictError 条目在读取之后被修改过
+type RevisionConflictError struct {
+	ItemID   string
+	Expected int
+	Current  int
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("条目 %s 已被修改（revision %d → %d），请刷新后重试", e.ItemID, e.Expected, e.Current)
+}
+
+var errItemNotDeletable = errors.New("条目不能删除")
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/client"
 	"go.uber.org/zap"
 )
@@ -27,48 +34,86 @@ func main() {
 	}
 	defer logger.Sync()
 
-	// 初始化数据库
-	if err := initDatabase(); err != nil {
-		logger.Fatal("数据库连接失败", zap.Error(err))
+	// 子命令，未指定时在一个进程中完成迁移、演示数据、API 和 Worker
+	name, args := "all", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	command, ok := findCommand(name)
+	if !ok {
+		printUsage()
+		os.Exit(2)
 	}
 
-	// 初始化演示数据
-	InitDemoData()
-
-	// 连接 Temporal Server
-	temporalClient, err = client.Dial(client.Options{
-		HostPort: "127.0.0.1:7233",
-	})
+	// 加载配置
+	cfg, err := loadConfig(args)
 	if err != nil {
-		logger.Fatal("无法连接 Temporal", zap.Error(err))
+		logger.Fatal("配置加载失败", zap.Error(err))
 	}
-	defer temporalClient.Close()
+	logger.Info("配置已加载:\n" + cfg.Redacted())
+	TaskQueue = cfg.Temporal.TaskQueue
 
-	// 启动 Worker
-	go StartWorker(temporalClient)
+	// 收到 SIGINT/SIGTERM 后停止接收新请求，处理完进行中的请求和 Activity 再退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// 设置 Gin
+	if err := command.run(ctx, cfg); err != nil {
+		logger.Fatal("命令执行失败", zap.String("command", name), zap.Error(err))
+	}
+}
+
+// newRouter 注册 API 路由
+func newRouter(cfg *Config) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
 	r.Use(gin.Recovery())
-	r.Use(cors.Default())
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AddAllowHeaders(headerAuthUser, headerAuthTimestamp, headerAuthSignature)
+	r.Use(cors.New(corsConfig))
+	r.Use(authMiddleware(cfg.Auth))
 
 	// 条目管理 API
 	r.GET("/api/items", listItems)
 	r.POST("/api/items", createItem)
+	r.POST("/api/items/import", importItemsHandler)
+	r.GET("/api/items/export", exportItemsHandler)
 	r.GET("/api/items/:itemId", getItem)
+	r.PATCH("/api/items/:itemId", updateItemHandler)
+	r.POST("/api/items/:itemId/close", closeItemHandler)
+	r.DELETE("/api/items/:itemId", deleteItemHandler)
+	r.POST("/api/items/:itemId/transition", transitionItemHandler)
+	r.GET("/api/items/:itemId/transitions", listItemTransitions)
 
 	// 版本管理 API
 	r.GET("/api/versions", listVersions)
 	r.POST("/api/versions", createVersion)
 	r.GET("/api/versions/:versionId/status", getVersionStatus)
+	r.GET("/api/versions/:versionId/history", getVersionHistory)
+	r.GET("/api/versions/:versionId/audit", getVersionAudit)
+	r.GET("/api/versions/:versionId/suspensions", listSuspensions)
+	r.GET("/api/versions/:versionId/items/export", exportVersionItemsHandler)
+	r.GET("/api/versions/:versionId/release-notes", getReleaseNoteHandler)
+	r.POST("/api/versions/:versionId/suspensions/:id/confirm", confirmSuspension)
+	r.POST("/api/versions/:versionId/cancel", cancelVersion)
+	r.POST("/api/versions/:versionId/pause", pauseVersionHandler)
+	r.POST("/api/versions/:versionId/resume", resumeVersionHandler)
 
 	// 流程配置 API
 	r.GET("/api/flow-configs", listFlowConfigs)
 	r.POST("/api/flow-configs", createFlowConfigHandler)
+	r.POST("/api/flow-configs/simulate", simulateFlowHandler)
 	r.GET("/api/flow-configs/:id", getFlowConfigHandler)
 	r.PUT("/api/flow-configs/:id", updateFlowConfigHandler)
 	r.DELETE("/api/flow-configs/:id", deleteFlowConfigHandler)
+	r.POST("/api/flow-configs/:id/emergency", setEmergencyFlowConfigHandler)
+	r.GET("/api/flow-configs/:id/revisions", listFlowRevisions)
+	r.GET("/api/flow-configs/:id/revisions/:revision", getFlowRevisionHandler)
+	r.GET("/api/flow-configs/:id/diff", diffFlowRevisions)
+
+	// 通知偏好 API
+	r.GET("/api/users/:user/notify-preference", getNotifyPreference)
+	r.PUT("/api/users/:user/notify-preference", updateNotifyPreference)
 
 	// 流程操作 API
 	r.POST("/api/workflow/:stage/approve", submitApproval)
@@ -76,30 +121,28 @@ func main() {
 
 	// 静态文件
 	r.NoRoute(func(c *gin.Context) {
-		c.File("../frontend/index.html")
+		c.File(cfg.Server.FrontendPath)
 	})
-
-	logger.Info("========================================")
-	logger.Info("BSS3.0 升级流程管理系统")
-	logger.Info("========================================")
-	logger.Info("后端服务启动", zap.String("addr", "http://localhost:8082"))
-
-	if err := r.Run(":8082"); err != nil {
-		logger.Fatal("服务启动失败", zap.Error(err))
-	}
+	return r
 }
 
 // ============================================================
 // 条目管理
 // ============================================================
 
+// listItems 条目列表，查询参数见 query.go
 func listItems(c *gin.Context) {
-	items, err := GetAllItems()
+	q, err := parseItemQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := store.ListItems(q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"items": items})
+	c.JSON(http.StatusOK, page)
 }
 
 func createItem(c *gin.Context) {
@@ -109,8 +152,11 @@ func createItem(c *gin.Context) {
 		return
 	}
 
+	var testCases []byte
+	if len(req.TestCases) > 0 {
+		testCases, _ = json.Marshal(req.TestCases)
+	}
 	item := ItemModel{
-		ID:            GenerateItemID(),
 		Name:          req.Name,
 		Type:          req.Type,
 		RequirementID: req.RequirementID,
@@ -124,9 +170,10 @@ func createItem(c *gin.Context) {
 		BTEResult:     TestResultPending,
 		GrayResult:    TestResultPending,
 		ProdResult:    TestResultPending,
+		TestCases:     string(testCases),
 	}
 
-	if err := CreateItem(&item); err != nil {
+	if err := store.CreateItem(&item); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -137,25 +184,264 @@ func createItem(c *gin.Context) {
 
 func getItem(c *gin.Context) {
 	itemID := c.Param("itemId")
-	item, err := GetItemByID(itemID)
+	item, err := store.GetItemByID(itemID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// transitionItemHandler 手工变更条目状态，只能变更到 manualItemStatuses 中的状态
+func transitionItemHandler(c *gin.Context) {
+	itemID := c.Param("itemId")
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !containsKey(manualItemStatuses, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "状态 " + req.Status + " 由升级流程变更，不能手工设置"})
+		return
+	}
+	item, err := store.GetItemByID(itemID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
 		return
 	}
+	if item.VersionID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "条目已被版本 " + item.VersionID + " 占用，版本结束前不能手工变更状态"})
+		return
+	}
+
+	transition := ItemTransitionRequest{
+		ItemIDs:  []string{itemID},
+		To:       req.Status,
+		Operator: operator,
+		Reason:   req.Reason,
+	}
+	if err := store.TransitionItems(transition); err != nil {
+		var illegal *IllegalTransitionError
+		if errors.As(err, &illegal) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, _ = store.GetItemByID(itemID)
+	logger.Info("条目状态已变更",
+		zap.String("itemId", itemID),
+		zap.String("status", req.Status),
+		zap.String("operator", operator))
 	c.JSON(http.StatusOK, item)
 }
 
+// updateItemHandler 修改条目，可修改的字段由条目状态决定，见 item_state.go
+func updateItemHandler(c *gin.Context) {
+	itemID := c.Param("itemId")
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	var req UpdateItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Revision == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision 不能为空，请带上读取条目时的 revision"})
+		return
+	}
+	changes, err := itemChanges(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := store.GetItemByID(itemID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+		return
+	}
+
+	item, err := store.UpdateItem(itemID, *req.Revision, changes)
+	if err != nil {
+		writeItemError(c, err)
+		return
+	}
+	logger.Info("条目已修改",
+		zap.String("itemId", itemID),
+		zap.String("operator", operator),
+		zap.Int("revision", item.Revision))
+	c.JSON(http.StatusOK, item)
+}
+
+// itemChanges 请求中出现的字段及新值，键为字段名
+func itemChanges(req UpdateItemRequest) (map[string]interface{}, error) {
+	changes := make(map[string]interface{})
+	strs := map[string]*string{
+		"name":           req.Name,
+		"type":           req.Type,
+		"requirement_id": req.RequirementID,
+		"developer":      req.Developer,
+		"tester":         req.Tester,
+		"item_owner":     req.ItemOwner,
+		"bte_result":     req.BTEResult,
+		"gray_result":    req.GrayResult,
+		"prod_result":    req.ProdResult,
+	}
+	for field, value := range strs {
+		if value != nil {
+			changes[field] = strings.TrimSpace(*value)
+		}
+	}
+	bools := map[string]*bool{
+		"has_script":  req.HasScript,
+		"has_cache":   req.HasCache,
+		"has_restart": req.HasRestart,
+	}
+	for field, value := range bools {
+		if value != nil {
+			changes[field] = *value
+		}
+	}
+	if req.TestCases != nil {
+		data, _ := json.Marshal(*req.TestCases)
+		changes["test_cases"] = string(data)
+	}
+
+	if name, ok := changes["name"]; ok && name == "" {
+		return nil, errors.New("条目名称不能为空")
+	}
+	results := []string{TestResultPending, TestResultPassed, TestResultFailed, TestResultSkipped}
+	for _, field := range itemResultFields {
+		if result, ok := changes[field]; ok && !containsKey(results, result.(string)) {
+			return nil, errors.New(field + " 无效，可选 " + strings.Join(results, "/"))
+		}
+	}
+	return changes, nil
+}
+
+// closeItemHandler 关闭未加入版本的条目，关闭原因必填
+func closeItemHandler(c *gin.Context) {
+	itemID := c.Param("itemId")
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	var req CloseItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Revision == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision 不能为空，请带上读取条目时的 revision"})
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "关闭原因不能为空"})
+		return
+	}
+	if _, err := store.GetItemByID(itemID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+		return
+	}
+
+	transition := ItemTransitionRequest{Operator: operator, Reason: strings.TrimSpace(req.Reason)}
+	if err := store.CloseItem(itemID, *req.Revision, transition); err != nil {
+		writeItemError(c, err)
+		return
+	}
+
+	item, _ := store.GetItemByID(itemID)
+	logger.Info("条目已关闭",
+		zap.String("itemId", itemID),
+		zap.String("operator", operator),
+		zap.String("reason", req.Reason))
+	c.JSON(http.StatusOK, item)
+}
+
+// deleteItemHandler 删除登记错误的条目，revision 通过查询参数传入
+func deleteItemHandler(c *gin.Context) {
+	itemID := c.Param("itemId")
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	revision, err := strconv.Atoi(c.Query("revision"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision 无效，请带上读取条目时的 revision"})
+		return
+	}
+	if _, err := store.GetItemByID(itemID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "条目不存在"})
+		return
+	}
+
+	if err := store.DeleteItem(itemID, revision); err != nil {
+		writeItemError(c, err)
+		return
+	}
+	logger.Info("条目已删除", zap.String("itemId", itemID), zap.String("operator", operator))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// writeItemError 条目修改失败的响应：并发修改和状态不允许的操作返回 409
+func writeItemError(c *gin.Context, err error) {
+	var conflict *RevisionConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "current_revision": conflict.Current})
+		return
+	}
+
+	var fields *ItemFieldError
+	var occupied *ItemOccupiedError
+	var illegal *IllegalTransitionError
+	if errors.As(err, &fields) || errors.As(err, &occupied) || errors.As(err, &illegal) || errors.Is(err, errItemNotDeletable) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// listItemTransitions 条目状态变更历史
+func listItemTransitions(c *gin.Context) {
+	transitions, err := store.GetItemTransitions(c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, transitions)
+}
+
 // ============================================================
 // 版本管理
 // ============================================================
 
+// listVersions 版本列表，查询参数见 query.go
 func listVersions(c *gin.Context) {
-	versions, err := GetAllVersions()
+	q, err := parseVersionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := store.ListVersions(q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, versions)
+	c.JSON(http.StatusOK, page)
 }
 
 func createVersion(c *gin.Context) {
@@ -167,6 +453,7 @@ func createVersion(c *gin.Context) {
 		GrayTester   string   `json:"gray_tester"`
 		ProdTester   string   `json:"prod_tester"`
 		IsUrgent     bool     `json:"is_urgent"`
+		UrgentReason string   `json:"urgent_reason"`
 		ItemIDs      []string `json:"item_ids"`
 		FlowConfigID uint     `json:"flow_config_id"`
 	}
@@ -174,27 +461,56 @@ func createVersion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if len(req.ItemIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要一个条目"})
+		return
+	}
+	req.UrgentReason = strings.TrimSpace(req.UrgentReason)
+	if req.IsUrgent && req.UrgentReason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "紧急版本需要填写紧急升级理由"})
+		return
+	}
 
-	// 获取流程配置
+	// 获取流程配置，未指定时按是否紧急选择
 	var flowConfig *FlowConfig
 	var err error
 	if req.FlowConfigID > 0 {
-		flowConfig, err = GetFlowConfig(req.FlowConfigID)
+		flowConfig, err = store.GetFlowConfig(req.FlowConfigID)
 	} else {
-		// 使用默认配置
-		var configs []FlowConfig
-		db.Where("is_default = ?", true).First(&configs)
-		if len(configs) > 0 {
-			flowConfig = &configs[0]
-		}
+		flowConfig, err = selectFlowConfig(req.IsUrgent)
 	}
 	if err != nil || flowConfig == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "流程配置不存在"})
 		return
 	}
+	if flowConfig.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流程配置已归档"})
+		return
+	}
 
-	// 获取流程阶段
-	stages, _ := GetFlowStages(flowConfig)
+	// 固定使用当前最新修订
+	revision, err := store.GetFlowRevision(flowConfig.ID, flowConfig.Revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "流程配置修订不存在"})
+		return
+	}
+
+	// 获取流程阶段，紧急版本从理由确认开始
+	stages, _ := GetRevisionStages(revision)
+	if req.IsUrgent {
+		stages = urgentStages(stages)
+	}
+	approvers := UpgradeVersion{
+		VersionOwner: req.VersionOwner,
+		VendorOwner:  req.VendorOwner,
+		BTETester:    req.BTETester,
+		GrayTester:   req.GrayTester,
+		ProdTester:   req.ProdTester,
+	}
+	if err := validateVersionApprovers(stages, approvers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	var firstStage string
 	for _, s := range stages {
 		if s.Enabled {
@@ -203,11 +519,9 @@ func createVersion(c *gin.Context) {
 		}
 	}
 
-	versionID := GenerateVersionID()
 	itemIDsJSON, _ := json.Marshal(req.ItemIDs)
 
 	version := VersionModel{
-		ID:           versionID,
 		Name:         req.Name,
 		VersionOwner: req.VersionOwner,
 		VendorOwner:  req.VendorOwner,
@@ -215,31 +529,33 @@ func createVersion(c *gin.Context) {
 		GrayTester:   req.GrayTester,
 		ProdTester:   req.ProdTester,
 		IsUrgent:     req.IsUrgent,
+		UrgentReason: req.UrgentReason,
 		Status:       "running",
 		CurrentStage: firstStage,
 		ItemIDs:      string(itemIDsJSON),
 		FlowConfigID: flowConfig.ID,
+
+		FlowRevisionID: revision.ID,
 	}
 
-	if err := CreateVersion(&version); err != nil {
+	// 创建版本、分配版本编号并独占条目，有条目不符合条件时返回逐条原因
+	rejections, err := store.CreateVersionWithItems(&version, req.ItemIDs)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if len(rejections) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "部分条目不能加入版本", "rejected": rejections})
+		return
+	}
+	versionID := version.ID
 
 	// 收集条目信息
 	var itemList []UpgradeItem
 	for _, itemID := range req.ItemIDs {
-		item, _ := GetItemByID(itemID)
+		item, _ := store.GetItemByID(itemID)
 		if item != nil {
-			itemList = append(itemList, UpgradeItem{
-				ID:        item.ID,
-				Name:      item.Name,
-				Type:      item.Type,
-				Developer: item.Developer,
-				Tester:    item.Tester,
-				ItemOwner: item.ItemOwner,
-				Status:    item.Status,
-			})
+			itemList = append(itemList, toUpgradeItem(item))
 		}
 	}
 
@@ -250,6 +566,7 @@ func createVersion(c *gin.Context) {
 		client.StartWorkflowOptions{
 			ID:        workflowID,
 			TaskQueue: TaskQueue,
+			Memo:      upgradeWorkflowMemo(),
 		},
 		UpgradeWorkflow,
 		UpgradeWorkflowRequest{
@@ -257,39 +574,73 @@ func createVersion(c *gin.Context) {
 				ID:           versionID,
 				Name:         req.Name,
 				VersionOwner: req.VersionOwner,
+				VendorOwner:  req.VendorOwner,
+				BTETester:    req.BTETester,
+				GrayTester:   req.GrayTester,
+				ProdTester:   req.ProdTester,
 				IsUrgent:     req.IsUrgent,
+				UrgentReason: req.UrgentReason,
 				CurrentStage: firstStage,
 				ItemIDs:      req.ItemIDs,
 			},
-			Items:        itemList,
-			FlowConfigID: flowConfig.ID,
+			Items:          itemList,
+			FlowConfigID:   flowConfig.ID,
+			FlowRevisionID: revision.ID,
 		},
 	)
 	if err != nil {
+		// 流程没有启动，版本失败并释放条目
+		result := UpgradeWorkflowResult{VersionID: versionID, Status: "failed", CurrentStage: firstStage, Message: "启动流程失败: " + err.Error()}
+		if ferr := store.FinishVersion(result, time.Now()); ferr != nil {
+			logger.Error("释放版本条目失败", zap.String("versionId", versionID), zap.Error(ferr))
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 更新 workflowID
-	version.WorkflowID = workflowID
-	UpdateVersion(&version)
+	// 只更新 workflow_id：流程已经开始，Activity 会同时写入阶段、状态和条目列表
+	if err := store.SetVersionWorkflowID(versionID, workflowID); err != nil {
+		logger.Error("记录 workflowID 失败", zap.String("versionId", versionID), zap.String("workflowId", workflowID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":       "流程已启动，记录 workflow_id 失败: " + err.Error(),
+			"workflow_id": workflowID,
+			"version_id":  versionID,
+		})
+		return
+	}
 
 	logger.Info("升级版本已创建",
 		zap.String("versionId", versionID),
 		zap.String("workflowId", workflowID),
-		zap.Uint("flowConfigId", flowConfig.ID))
+		zap.Uint("flowConfigId", flowConfig.ID),
+		zap.Int("revision", revision.Revision),
+		zap.Bool("urgent", req.IsUrgent))
 
 	c.JSON(http.StatusOK, gin.H{
-		"workflow_id": we.GetID(),
-		"version_id":  versionID,
-		"run_id":      we.GetRunID(),
+		"workflow_id":    we.GetID(),
+		"version_id":     versionID,
+		"run_id":         we.GetRunID(),
+		"flow_config_id": flowConfig.ID,
+		"is_urgent":      req.IsUrgent,
 	})
 }
 
+// selectFlowConfig 未指定流程配置时使用的流程：紧急版本使用紧急流程，没有紧急流程时使用默认流程
+func selectFlowConfig(urgent bool) (*FlowConfig, error) {
+	if urgent {
+		config, err := store.GetEmergencyFlowConfig()
+		if err == nil {
+			return config, nil
+		}
+		logger.Warn("未指定紧急流程配置，紧急版本使用默认流程", zap.Error(err))
+	}
+	return store.GetDefaultFlowConfig()
+}
+
 func getVersionStatus(c *gin.Context) {
 	versionID := c.Param("versionId")
 
-	version, err := GetVersionByID(versionID)
+	version, err := store.GetVersionByID(versionID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
 		return
@@ -302,58 +653,217 @@ func getVersionStatus(c *gin.Context) {
 	// 收集条目信息
 	var itemList []ItemModel
 	for _, itemID := range itemIDs {
-		item, _ := GetItemByID(itemID)
+		item, _ := store.GetItemByID(itemID)
 		if item != nil {
 			itemList = append(itemList, *item)
 		}
 	}
 
-	// 获取流程配置
-	flowConfig, _ := GetFlowConfig(version.FlowConfigID)
-	var stages []StageConfig
-	if flowConfig != nil {
-		stages, _ = GetFlowStages(flowConfig)
-	}
-
 	// 查询 Temporal 工作流状态
 	workflowID := "upgrade-" + versionID
 	desc, err := temporalClient.DescribeWorkflowExecution(c.Request.Context(), workflowID, "")
 	status := version.Status
+	running := false
 	if err == nil {
 		status = desc.WorkflowExecutionInfo.Status.String()
+		running = desc.WorkflowExecutionInfo.Status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING
+	}
+
+	// 运行中的工作流以 Query 结果为准
+	if running {
+		state, err := queryWorkflowState(c.Request.Context(), workflowID)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"version_id":        versionID,
+				"version_name":      version.Name,
+				"is_urgent":         version.IsUrgent,
+				"urgent_reason":     version.UrgentReason,
+				"status":            status,
+				"state":             state.Status,
+				"pause":             state.Pause,
+				"current_stage":     state.CurrentStage,
+				"stage_started_at":  state.StageStartedAt,
+				"pending_approvers": state.PendingApprovers,
+				"pending_items":     state.PendingItems,
+				"active_stages":     state.ActiveStages,
+				"approvals":         state.Approvals,
+				"item_results":      state.ItemResults,
+				"items":             itemList,
+				"timeline":          state.Timeline,
+			})
+			return
+		}
+		logger.Warn("查询工作流状态失败，使用数据库状态", zap.String("workflowId", workflowID), zap.Error(err))
 	}
 
+	// 已关闭的工作流使用数据库记录，阶段取版本固定的修订
+	stages, _ := LoadVersionStages(version)
+	history, _ := store.GetStageHistory(versionID)
+	audits, _ := store.GetAudits(versionID, "")
+
 	c.JSON(http.StatusOK, gin.H{
 		"version_id":    versionID,
 		"version_name":  version.Name,
+		"is_urgent":     version.IsUrgent,
+		"urgent_reason": version.UrgentReason,
 		"status":        status,
+		"state":         version.Status,
+		"message":       version.Message,
 		"current_stage": version.CurrentStage,
 		"items":         itemList,
-		"timeline":      buildTimelineFromConfig(stages, version.CurrentStage),
+		"timeline":      buildTimeline(stages, history, audits),
 	})
 }
 
-func buildTimelineFromConfig(stages []StageConfig, currentStage string) []gin.H {
-	var timeline []gin.H
-	currentFound := false
+// queryWorkflowState 通过 Query 获取工作流实时状态
+func queryWorkflowState(ctx context.Context, workflowID string) (*WorkflowState, error) {
+	resp, err := temporalClient.QueryWorkflow(ctx, workflowID, "", QueryWorkflowState)
+	if err != nil {
+		return nil, err
+	}
+	var state WorkflowState
+	if err := resp.Get(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
 
-	for _, stage := range stages {
-		if !stage.Enabled {
-			continue
+func getVersionHistory(c *gin.Context) {
+	history, err := store.GetStageHistory(c.Param("versionId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// getVersionAudit 版本审计记录，可按阶段过滤
+func getVersionAudit(c *gin.Context) {
+	audits, err := store.GetAudits(c.Param("versionId"), c.Query("stage"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, audits)
+}
+
+func listSuspensions(c *gin.Context) {
+	suspensions, err := store.GetSuspensionsByVersion(c.Param("versionId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, suspensions)
+}
+
+// confirmSuspension 版本负责人确认条目挂起决定
+func confirmSuspension(c *gin.Context) {
+	versionID := c.Param("versionId")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	if _, err := store.GetVersionByID(versionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return
+	}
+	ok, err := CheckPermission(versionID, operator, ActConfirm, ObjSuspension)
+	if err != nil || !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有版本负责人可以确认挂起"})
+		return
+	}
+
+	suspension, err := store.GetSuspensionByID(uint(id))
+	if err != nil || suspension.VersionID != versionID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "挂起记录不存在"})
+		return
+	}
+	if suspension.Confirmed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "挂起记录已确认"})
+		return
+	}
+
+	now := time.Now()
+	suspension.Confirmed = true
+	suspension.ConfirmedBy = operator
+	suspension.ConfirmedAt = &now
+	if err := store.UpdateSuspension(suspension); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	store.CreateAudit(AuditEvent{
+		VersionID:  versionID,
+		Stage:      suspension.Stage,
+		EventType:  AuditSuspendConfirm,
+		Operator:   operator,
+		ItemID:     suspension.ItemID,
+		Passed:     true,
+		Comment:    suspension.Reason,
+		OccurredAt: now,
+	})
+
+	logger.Info("条目挂起已确认",
+		zap.String("versionId", versionID),
+		zap.String("itemId", suspension.ItemID),
+		zap.String("operator", operator))
+	c.JSON(http.StatusOK, suspension)
+}
+
+// buildTimeline 根据阶段历史和审计记录构建时间线
+// 已执行的阶段按历史顺序排列（退回后重新执行的阶段会出现多次，并行阶段按开始时间交错），之后是待执行阶段
+func buildTimeline(stages []StageConfig, history []StageHistoryModel, audits []AuditModel) []StageTimeline {
+	var timeline []StageTimeline
+
+	for _, h := range history {
+		entry := StageTimeline{
+			Stage:     h.StageName,
+			Key:       h.Stage,
+			Status:    h.Status,
+			Iteration: h.Iteration,
+			StartedAt: h.StartedAt.Format(time.RFC3339),
+			Operator:  h.Operator,
+		}
+		if h.CompletedAt != nil {
+			entry.CompletedAt = h.CompletedAt.Format(time.RFC3339)
 		}
+		timeline = append(timeline, entry)
+	}
 
-		status := "pending"
-		if stage.Key == currentStage {
-			status = "in_progress"
-			currentFound = true
-		} else if !currentFound {
-			status = "completed"
+	// 审计记录挂到同阶段最近一次开始于其之前的执行上
+	for _, a := range audits {
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Stage == a.Stage && !history[i].StartedAt.After(a.OccurredAt) {
+				timeline[i].Audits = append(timeline[i].Audits, AuditEvent{
+					VersionID:  a.VersionID,
+					Stage:      a.Stage,
+					EventType:  a.EventType,
+					Operator:   a.Operator,
+					ItemID:     a.ItemID,
+					Passed:     a.Passed,
+					Comment:    a.Comment,
+					OccurredAt: a.OccurredAt,
+				})
+				break
+			}
 		}
+	}
 
-		timeline = append(timeline, gin.H{
-			"stage":  stage.Name,
-			"key":    stage.Key,
-			"status": status,
+	// 尚未执行过的启用阶段为待执行，按配置顺序
+	executed := make(map[string]bool, len(history))
+	for _, h := range history {
+		executed[h.Stage] = true
+	}
+	for _, stage := range stages {
+		if !stage.Enabled || executed[stage.Key] {
+			continue
+		}
+		timeline = append(timeline, StageTimeline{
+			Stage:  stage.Name,
+			Key:    stage.Key,
+			Status: "pending",
 		})
 	}
 
@@ -365,7 +875,7 @@ func buildTimelineFromConfig(stages []StageConfig, currentStage string) []gin.H
 // ============================================================
 
 func listFlowConfigs(c *gin.Context) {
-	configs, err := GetFlowConfigs()
+	configs, err := store.GetFlowConfigs(c.Query("include_archived") == "true")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -376,12 +886,15 @@ func listFlowConfigs(c *gin.Context) {
 	for _, config := range configs {
 		stages, _ := GetFlowStages(&config)
 		result = append(result, gin.H{
-			"id":          config.ID,
-			"name":        config.Name,
-			"description": config.Description,
-			"stages":      stages,
-			"is_default":  config.IsDefault,
-			"created_at":  config.CreatedAt,
+			"id":           config.ID,
+			"name":         config.Name,
+			"description":  config.Description,
+			"stages":       stages,
+			"is_default":   config.IsDefault,
+			"is_emergency": config.IsEmergency,
+			"revision":     config.Revision,
+			"archived":     config.Archived,
+			"created_at":   config.CreatedAt,
 		})
 	}
 
@@ -390,7 +903,7 @@ func listFlowConfigs(c *gin.Context) {
 
 func getFlowConfigHandler(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-	config, err := GetFlowConfig(uint(id))
+	config, err := store.GetFlowConfig(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
 		return
@@ -398,11 +911,14 @@ func getFlowConfigHandler(c *gin.Context) {
 
 	stages, _ := GetFlowStages(config)
 	c.JSON(http.StatusOK, gin.H{
-		"id":          config.ID,
-		"name":        config.Name,
-		"description": config.Description,
-		"stages":      stages,
-		"is_default":  config.IsDefault,
+		"id":           config.ID,
+		"name":         config.Name,
+		"description":  config.Description,
+		"stages":       stages,
+		"is_default":   config.IsDefault,
+		"is_emergency": config.IsEmergency,
+		"revision":     config.Revision,
+		"archived":     config.Archived,
 	})
 }
 
@@ -417,6 +933,11 @@ func createFlowConfigHandler(c *gin.Context) {
 		return
 	}
 
+	if err := ValidateFlowConfig(req.Name, req.Stages); err != nil {
+		c.JSON(http.StatusBadRequest, validationResponse(err))
+		return
+	}
+
 	stagesJSON, _ := json.Marshal(req.Stages)
 	config := FlowConfig{
 		Name:        req.Name,
@@ -424,7 +945,7 @@ func createFlowConfigHandler(c *gin.Context) {
 		Stages:      string(stagesJSON),
 	}
 
-	if err := CreateFlowConfig(&config); err != nil {
+	if err := store.CreateFlowConfig(&config); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -435,12 +956,17 @@ func createFlowConfigHandler(c *gin.Context) {
 
 func updateFlowConfigHandler(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
-	config, err := GetFlowConfig(uint(id))
+	config, err := store.GetFlowConfig(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
 		return
 	}
 
+	if config.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流程配置已归档，不能修改"})
+		return
+	}
+
 	var req struct {
 		Name        string        `json:"name"`
 		Description string        `json:"description"`
@@ -451,24 +977,29 @@ func updateFlowConfigHandler(c *gin.Context) {
 		return
 	}
 
+	if err := ValidateFlowConfig(req.Name, req.Stages); err != nil {
+		c.JSON(http.StatusBadRequest, validationResponse(err))
+		return
+	}
+
 	stagesJSON, _ := json.Marshal(req.Stages)
 	config.Name = req.Name
 	config.Description = req.Description
 	config.Stages = string(stagesJSON)
 
-	if err := UpdateFlowConfig(config); err != nil {
+	if err := store.UpdateFlowConfig(config); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	logger.Info("流程配置已更新", zap.Uint("id", config.ID))
+	logger.Info("流程配置已更新", zap.Uint("id", config.ID), zap.Int("revision", config.Revision))
 	c.JSON(http.StatusOK, config)
 }
 
 func deleteFlowConfigHandler(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 
-	config, err := GetFlowConfig(uint(id))
+	config, err := store.GetFlowConfig(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
 		return
@@ -478,16 +1009,180 @@ func deleteFlowConfigHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除默认配置"})
 		return
 	}
+	if config.IsEmergency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除紧急流程配置，请先指定其他紧急流程"})
+		return
+	}
 
-	if err := DeleteFlowConfig(uint(id)); err != nil {
+	// 删除即归档，引用该配置的版本继续使用各自固定的修订
+	if err := store.ArchiveFlowConfig(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	logger.Info("流程配置已删除", zap.Uint("id", uint(id)))
+	logger.Info("流程配置已归档", zap.Uint("id", uint(id)))
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// setEmergencyFlowConfigHandler 指定紧急版本默认使用的流程配置
+func setEmergencyFlowConfigHandler(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	config, err := store.GetFlowConfig(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
+		return
+	}
+	if config.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流程配置已归档"})
+		return
+	}
+
+	if err := store.SetEmergencyFlowConfig(config.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("紧急流程配置已指定", zap.Uint("id", config.ID), zap.String("name", config.Name))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// validationResponse 校验失败的响应，包含字段错误列表
+func validationResponse(err error) gin.H {
+	resp := gin.H{"error": "流程配置无效: " + err.Error()}
+	if errs, ok := err.(ValidationErrors); ok {
+		resp["fields"] = errs
+	}
+	return resp
+}
+
+func listFlowRevisions(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	revisions, err := store.GetFlowRevisions(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var result []gin.H
+	for _, rev := range revisions {
+		stages, _ := GetRevisionStages(&rev)
+		result = append(result, gin.H{
+			"id":          rev.ID,
+			"revision":    rev.Revision,
+			"name":        rev.Name,
+			"description": rev.Description,
+			"stages":      stages,
+			"created_at":  rev.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func getFlowRevisionHandler(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	revision, _ := strconv.Atoi(c.Param("revision"))
+	rev, err := store.GetFlowRevision(uint(id), revision)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "修订不存在"})
+		return
+	}
+
+	stages, _ := GetRevisionStages(rev)
+	c.JSON(http.StatusOK, gin.H{
+		"id":          rev.ID,
+		"revision":    rev.Revision,
+		"name":        rev.Name,
+		"description": rev.Description,
+		"stages":      stages,
+		"created_at":  rev.CreatedAt,
+	})
+}
+
+// diffFlowRevisions 对比两个修订，to 默认为最新修订，from 默认为 to 的上一个修订
+func diffFlowRevisions(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	config, err := store.GetFlowConfig(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
+		return
+	}
+
+	to := config.Revision
+	if v := c.Query("to"); v != "" {
+		to, _ = strconv.Atoi(v)
+	}
+	from := to - 1
+	if v := c.Query("from"); v != "" {
+		from, _ = strconv.Atoi(v)
+	}
+
+	fromRev, err := store.GetFlowRevision(config.ID, from)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "修订 " + strconv.Itoa(from) + " 不存在"})
+		return
+	}
+	toRev, err := store.GetFlowRevision(config.ID, to)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "修订 " + strconv.Itoa(to) + " 不存在"})
+		return
+	}
+
+	diff, err := DiffFlowRevisions(fromRev, toRev)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// ============================================================
+// 通知偏好
+// ============================================================
+
+func getNotifyPreference(c *gin.Context) {
+	pref, err := store.GetNotifyPreference(c.Param("user"))
+	if err != nil {
+		// 未设置时返回默认渠道
+		c.JSON(http.StatusOK, NotifyPreference{User: c.Param("user"), Channels: strings.Join(defaultChannels, ",")})
+		return
+	}
+	c.JSON(http.StatusOK, pref)
+}
+
+// updateNotifyPreference 修改通知偏好，只能修改认证用户自己的偏好
+func updateNotifyPreference(c *gin.Context) {
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	if operator != c.Param("user") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己的通知偏好"})
+		return
+	}
+
+	var pref NotifyPreference
+	if err := c.ShouldBindJSON(&pref); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pref.User = c.Param("user")
+
+	for _, channel := range pref.ChannelList() {
+		if !containsKey(allChannels, channel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知的通知渠道: " + channel})
+			return
+		}
+	}
+
+	if err := store.SaveNotifyPreference(&pref); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("通知偏好已更新", zap.String("user", pref.User), zap.String("channels", pref.Channels))
+	c.JSON(http.StatusOK, pref)
+}
+
 // ============================================================
 // 流程操作
 // ============================================================
@@ -498,7 +1193,6 @@ func submitApproval(c *gin.Context) {
 	var req struct {
 		WorkflowID string `json:"workflow_id"`
 		Action     struct {
-			Operator string `json:"operator"`
 			Approved bool   `json:"approved"`
 			Comment  string `json:"comment"`
 		} `json:"action"`
@@ -508,9 +1202,19 @@ func submitApproval(c *gin.Context) {
 		return
 	}
 
+	versionID := strings.TrimPrefix(req.WorkflowID, "upgrade-")
+	operator, ok := checkStagePermission(c, versionID, ActApprove, stage)
+	if !ok {
+		return
+	}
+	if stage == StageUrgentJustify && req.Action.Approved && strings.TrimSpace(req.Action.Comment) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "确认紧急升级需要填写说明"})
+		return
+	}
+
 	action := ApprovalAction{
 		Stage:     stage,
-		Operator:  req.Action.Operator,
+		Operator:  operator,
 		Approved:  req.Action.Approved,
 		Comment:   req.Action.Comment,
 		Timestamp: time.Now().Format(time.RFC3339),
@@ -522,73 +1226,187 @@ func submitApproval(c *gin.Context) {
 		return
 	}
 
-	// 只有通过时才更新版本当前阶段
-	if req.Action.Approved {
-		// 从 workflowID 解析 versionID
-		versionID := req.WorkflowID[8:] // 去掉 "upgrade-" 前缀
-		version, _ := GetVersionByID(versionID)
-		if version != nil {
-			// 获取流程配置
-			flowConfig, _ := GetFlowConfig(version.FlowConfigID)
-			if flowConfig != nil {
-				stages, _ := GetFlowStages(flowConfig)
-				version.CurrentStage = getNextStageFromConfig(stages, stage)
-				UpdateVersion(version)
-			}
-		}
-	}
-
 	logger.Info("阶段审批已提交",
 		zap.String("stage", stage),
-		zap.String("operator", req.Action.Operator),
+		zap.String("operator", operator),
 		zap.Bool("approved", req.Action.Approved))
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-func getNextStageFromConfig(stages []StageConfig, currentStage string) string {
-	found := false
-	for _, stage := range stages {
-		if found && stage.Enabled {
-			return stage.Key
-		}
-		if stage.Key == currentStage {
-			found = true
-		}
-	}
-	return StageCompleted
+// cancelVersion 取消版本，需要填写原因；进行中的阶段结束，条目恢复到版本开始时的状态
+func cancelVersion(c *gin.Context) {
+	signalVersion(c, SignalCancel, "running", "paused")
 }
 
-func submitTestResult(c *gin.Context) {
-	stage := c.Param("stage")
+// pauseVersionHandler 暂停版本，暂停期间阶段计时器停止计时
+func pauseVersionHandler(c *gin.Context) {
+	signalVersion(c, SignalPause, "running")
+}
 
+// resumeVersionHandler 恢复暂停的版本
+func resumeVersionHandler(c *gin.Context) {
+	signalVersion(c, SignalResume, "paused")
+}
+
+// signalVersion 校验版本状态和操作人权限后发送版本控制 Signal，allowed 为允许操作的版本状态
+func signalVersion(c *gin.Context, signal string, allowed ...string) {
+	versionID := c.Param("versionId")
+
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		WorkflowID string `json:"workflow_id"`
-		AllPassed  bool   `json:"all_passed"`
+		Reason string `json:"reason"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if signal == SignalCancel && strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "取消原因不能为空"})
+		return
+	}
 
-	err := temporalClient.SignalWorkflow(c.Request.Context(), req.WorkflowID, "", stage+"-test-complete", req.AllPassed)
+	version, err := store.GetVersionByID(versionID)
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return
+	}
+	if !containsKey(allowed, version.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "版本状态为 " + version.Status + "，不能执行该操作"})
+		return
+	}
+	ok, err = CheckPermission(versionID, operator, ActControl, ObjVersion)
+	if err != nil || !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "只有版本负责人可以取消、暂停或恢复版本"})
+		return
+	}
+
+	control := VersionControl{
+		Operator:  operator,
+		Reason:    req.Reason,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if err := temporalClient.SignalWorkflow(c.Request.Context(), "upgrade-"+versionID, "", signal, control); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 更新版本当前阶段
-	versionID := req.WorkflowID[8:]
-	version, _ := GetVersionByID(versionID)
-	if version != nil {
-		flowConfig, _ := GetFlowConfig(version.FlowConfigID)
-		if flowConfig != nil {
-			stages, _ := GetFlowStages(flowConfig)
-			version.CurrentStage = getNextStageFromConfig(stages, stage)
-			UpdateVersion(version)
-		}
+	logger.Info("版本控制已提交",
+		zap.String("versionId", versionID),
+		zap.String("signal", signal),
+		zap.String("operator", operator),
+		zap.String("reason", req.Reason))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func submitTestResult(c *gin.Context) {
+	stage := c.Param("stage")
+	if testResultColumn(stage) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "非测试阶段"})
+		return
 	}
 
-	logger.Info("测试结果已提交", zap.String("stage", stage), zap.Bool("allPassed", req.AllPassed))
+	var req SubmitTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Test.ItemID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "条目ID不能为空"})
+		return
+	}
+
+	versionID := strings.TrimPrefix(req.WorkflowID, "upgrade-")
+	tester, ok := checkStagePermission(c, versionID, ActTest, stage)
+	if !ok {
+		return
+	}
+
+	submission := req.Test
+	submission.Tester = tester
+	submission.Stage = stage
+	submission.SubmittedAt = time.Now().Format(time.RFC3339)
+
+	err := temporalClient.SignalWorkflow(c.Request.Context(), req.WorkflowID, "", stage+"-test-result", submission)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("测试结果已提交",
+		zap.String("stage", stage),
+		zap.String("itemId", submission.ItemID),
+		zap.String("tester", submission.Tester),
+		zap.Bool("passed", submission.Passed))
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+// checkStagePermission 校验认证用户的阶段权限，返回操作人；无权限时直接返回错误响应
+func checkStagePermission(c *gin.Context, versionID, act, stage string) (string, bool) {
+	operator, ok := requireOperator(c)
+	if !ok {
+		return "", false
+	}
+
+	ok, err := CheckPermission(versionID, operator, act, stage)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return "", false
+	}
+	if !ok {
+		logger.Warn("无权操作阶段",
+			zap.String("versionId", versionID),
+			zap.String("stage", stage),
+			zap.String("operator", operator),
+			zap.String("act", act))
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作该阶段"})
+		return "", false
+	}
+	return operator, true
+}
+
+// toUpgradeVersion 版本模型转换为工作流使用的版本信息
+func toUpgradeVersion(version *VersionModel) UpgradeVersion {
+	var itemIDs []string
+	json.Unmarshal([]byte(version.ItemIDs), &itemIDs)
+	return UpgradeVersion{
+		ID:           version.ID,
+		Name:         version.Name,
+		VersionOwner: version.VersionOwner,
+		VendorOwner:  version.VendorOwner,
+		BTETester:    version.BTETester,
+		GrayTester:   version.GrayTester,
+		ProdTester:   version.ProdTester,
+		IsUrgent:     version.IsUrgent,
+		UrgentReason: version.UrgentReason,
+		Status:       version.Status,
+		CurrentStage: version.CurrentStage,
+		ItemIDs:      itemIDs,
+		CreatedAt:    version.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func toUpgradeItem(item *ItemModel) UpgradeItem {
+	return UpgradeItem{
+		ID:            item.ID,
+		Name:          item.Name,
+		Type:          item.Type,
+		RequirementID: item.RequirementID,
+		Developer:     item.Developer,
+		Tester:        item.Tester,
+		ItemOwner:     item.ItemOwner,
+		Status:        item.Status,
+		HasScript:     item.HasScript,
+		HasCache:      item.HasCache,
+		HasRestart:    item.HasRestart,
+		BTEResult:     item.BTEResult,
+		GrayResult:    item.GrayResult,
+		ProdResult:    item.ProdResult,
+		CloseReason:   item.CloseReason,
+		TestCases:     item.TestCaseList(),
+		CreatedAt:     item.CreatedAt.Format(time.RFC3339),
+	}
+}
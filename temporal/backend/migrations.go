@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// 数据库迁移
+// 表结构和初始数据按版本号依次迁移，已执行的版本记录在 upgrade_schema_migrations：
+//   每个迁移和它的版本记录在同一个事务中提交（MySQL 的 DDL 会隐式提交，失败后检查表结构再重试）
+//   新的变更追加新的版本，不修改已发布的迁移；表结构变更使用 AutoMigrate，重复执行不会出错
+//   表结构迁移使用 migrations_schema.go 中的快照结构体，不使用业务模型，业务模型的修改不会改变已发布的迁移
+//   数据迁移 2-4 使用 FlowConfig、FlowRevision 写入数据，这两个模型增加字段时，这些迁移要改用快照结构体
+//   数据库版本低于程序时 serve/worker 拒绝启动，需要先执行 migrate；高于程序时拒绝迁移和启动
+// 不要同时执行多个 migrate
+// ============================================================
+
+// SchemaMigration 已执行的迁移
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:100" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string { return "upgrade_schema_migrations" }
+
+type migration struct {
+	version int
+	name    string
+	up      func(s *gormStore) error // s 使用迁移的事务
+}
+
+// migrations 按版本号排序
+var migrations = []migration{
+	{1, "创建表结构", migrateBaseline},
+	{2, "为流程配置补充修订", backfillFlowRevisions},
+	{3, "初始化默认流程配置", initDefaultFlowConfig},
+	{4, "指定紧急流程", backfillEmergencyFlowConfig},
+	{5, "条目测试用例和乐观锁版本号", migrateItemRevision},
+	{6, "版本发布说明", migrateReleaseNotes},
+	{7, "审计记录去重包含操作人", migrateAuditOperatorKey},
+}
+
+// latestSchemaVersion 程序需要的表结构版本
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (s *gormStore) Migrate() error {
+	return s.migrateTo(latestSchemaVersion())
+}
+
+// migrateTo 执行到 target 版本为止的迁移
+func (s *gormStore) migrateTo(target int) error {
+	if err := s.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("数据库表结构版本 %d 高于程序支持的 %d，请升级程序", current, latestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(&gormStore{db: tx, driver: s.driver}); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("迁移 %d（%s）失败: %w", m.version, m.name, err)
+		}
+		logger.Info("迁移已执行", zap.Int("version", m.version), zap.String("name", m.name))
+	}
+
+	logger.Info("数据库迁移完成", zap.Int("version", target))
+	return nil
+}
+
+func (s *gormStore) CheckSchema() error {
+	current := 0
+	if s.db.Migrator().HasTable(&SchemaMigration{}) {
+		var err error
+		if current, err = s.schemaVersion(); err != nil {
+			return err
+		}
+	}
+	switch latest := latestSchemaVersion(); {
+	case current < latest:
+		return fmt.Errorf("数据库表结构版本为 %d，程序需要 %d，请先执行 migrate", current, latest)
+	case current > latest:
+		return fmt.Errorf("数据库表结构版本 %d 高于程序支持的 %d，请升级程序", current, latest)
+	}
+	return nil
+}
+
+// schemaVersion 已执行的最高迁移版本
+func (s *gormStore) schemaVersion() (int, error) {
+	var version int
+	err := s.db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// ============================================================
+// 迁移
+// ============================================================
+
+// migrateBaseline 按基线快照创建所有表，迁移版本管理之前的数据库补充缺少的字段和索引
+func migrateBaseline(s *gormStore) error {
+	return s.db.AutoMigrate(&schemaV1FlowConfig{}, &schemaV1FlowRevision{}, &schemaV1Item{}, &schemaV1Version{},
+		&schemaV1Suspension{}, &schemaV1StageHistory{}, &schemaV1Audit{}, &schemaV1NotifyPreference{},
+		&schemaV1ItemTransition{}, &schemaV1Sequence{})
+}
+
+// migrateItemRevision 条目增加测试用例和乐观锁版本号，已有条目的版本号为 0
+func migrateItemRevision(s *gormStore) error {
+	return s.db.AutoMigrate(&schemaV5Item{})
+}
+
+// migrateReleaseNotes 创建版本发布说明表，迁移前已完成的版本没有发布说明
+func migrateReleaseNotes(s *gormStore) error {
+	return s.db.AutoMigrate(&schemaV6ReleaseNote{})
+}
+
+// migrateAuditOperatorKey 审计记录的唯一索引增加操作人，同一时间不同审批人的会签不再被当作重复事件
+func migrateAuditOperatorKey(s *gormStore) error {
+	if s.db.Migrator().HasIndex(&schemaV1Audit{}, "idx_audit_event") {
+		if err := s.db.Migrator().DropIndex(&schemaV1Audit{}, "idx_audit_event"); err != nil {
+			return err
+		}
+	}
+	return s.db.AutoMigrate(&schemaV7Audit{})
+}
+
+// prodFinalizeRoles 生产定版需要版本负责人和厂家负责人会签
+var prodFinalizeRoles = []string{RoleVersionOwner, RoleVendorOwner}
+
+// initDefaultFlowConfig 初始化默认流程配置
+func initDefaultFlowConfig(s *gormStore) error {
+	var count int64
+	if err := s.db.Model(&FlowConfig{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// 默认完整流程
+	defaultStages := []StageConfig{
+		{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
+		{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
+		{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
+		{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
+		{Key: StageGrayConfirm, Name: "灰度条目确认", Type: "approval", Enabled: true, Timeout: 48, Order: 5},
+		{Key: StageGrayFinalize, Name: "灰度定版", Type: "approval", Enabled: true, Timeout: 24, Order: 6},
+		{Key: StageGrayPrepare, Name: "灰度版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 7},
+		{Key: StageGrayTest, Name: "灰度测试", Type: "test", Enabled: true, Timeout: 96, Order: 8},
+		{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 9, Roles: prodFinalizeRoles, ApprovalMode: ApprovalModeAll},
+		{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 10},
+		{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 11},
+		{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 12},
+		{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 13},
+	}
+
+	stagesJSON, _ := json.Marshal(defaultStages)
+	defaultConfig := FlowConfig{
+		Name:        "默认升级流程",
+		Description: "包含完整的 BTE → 灰度 → 生产 测试流程",
+		Stages:      string(stagesJSON),
+		IsDefault:   true,
+	}
+	if err := s.CreateFlowConfig(&defaultConfig); err != nil {
+		return err
+	}
+
+	// 简化流程（跳过灰度）
+	simpleStages := []StageConfig{
+		{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
+		{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
+		{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
+		{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
+		{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 5, Roles: prodFinalizeRoles, ApprovalMode: ApprovalModeAll},
+		{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 6},
+		{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 7},
+		{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 8},
+		{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 9},
+	}
+	simpleJSON, _ := json.Marshal(simpleStages)
+	simpleConfig := FlowConfig{
+		Name:        "紧急升级流程",
+		Description: "跳过灰度测试，直接进入生产",
+		Stages:      string(simpleJSON),
+		IsDefault:   false,
+		IsEmergency: true,
+	}
+	if err := s.CreateFlowConfig(&simpleConfig); err != nil {
+		return err
+	}
+
+	logger.Info("默认流程配置已初始化")
+	return nil
+}
+
+// backfillEmergencyFlowConfig 没有紧急流程时指定初始化的"紧急升级流程"
+func backfillEmergencyFlowConfig(s *gormStore) error {
+	var count int64
+	if err := s.db.Model(&FlowConfig{}).Where("is_emergency = ?", true).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.db.Model(&FlowConfig{}).Where("name = ? AND archived = ?", "紧急升级流程", false).
+		Update("is_emergency", true).Error
+}
+
+// backfillFlowRevisions 为没有修订的流程配置生成第一个修订，并固定引用它们的版本
+func backfillFlowRevisions(s *gormStore) error {
+	var configs []FlowConfig
+	if err := s.db.Where("revision = ?", 0).Find(&configs).Error; err != nil {
+		return err
+	}
+	for i := range configs {
+		config := &configs[i]
+		config.Revision = 1
+		rev := newFlowRevision(config)
+		if err := s.db.Create(rev).Error; err != nil {
+			return err
+		}
+		if err := s.db.Model(&FlowConfig{}).Where("id = ?", config.ID).Update("revision", 1).Error; err != nil {
+			return err
+		}
+		err := s.db.Model(&VersionModel{}).
+			Where("flow_config_id = ? AND flow_revision_id = ?", config.ID, 0).
+			Update("flow_revision_id", rev.ID).Error
+		if err != nil {
+			return err
+		}
+		logger.Info("流程配置修订已补充", zap.Uint("id", config.ID))
+	}
+	return nil
+}
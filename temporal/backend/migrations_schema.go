@@ -0,0 +1,199 @@
+package main
+
+import "time"
+
+// ============================================================
+// 迁移表结构快照
+// 每个迁移使用自己的结构体描述当时的表结构，业务模型以后的修改不会改变已发布迁移创建的表：
+//   schemaV1* 为迁移 1 的基线表结构
+//   之后的迁移只包含本次增加的字段或表，AutoMigrate 只会补充缺少的字段和索引
+// 快照发布后不再修改，表结构变更追加新的迁移和快照
+// ============================================================
+
+// ---------- 迁移 1：基线 ----------
+
+type schemaV1FlowConfig struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"size:100;not null"`
+	Description string `gorm:"size:500"`
+	Stages      string `gorm:"type:text"`
+	IsDefault   bool   `gorm:"default:false"`
+	IsEmergency bool   `gorm:"default:false"`
+	Revision    int
+	Archived    bool `gorm:"default:false"`
+	ArchivedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (schemaV1FlowConfig) TableName() string { return "flow_configs" }
+
+type schemaV1FlowRevision struct {
+	ID           uint   `gorm:"primaryKey"`
+	FlowConfigID uint   `gorm:"uniqueIndex:idx_flow_revision"`
+	Revision     int    `gorm:"uniqueIndex:idx_flow_revision"`
+	Name         string `gorm:"size:100;not null"`
+	Description  string `gorm:"size:500"`
+	Stages       string `gorm:"type:text"`
+	CreatedAt    time.Time
+}
+
+func (schemaV1FlowRevision) TableName() string { return "upgrade_flow_config_revisions" }
+
+type schemaV1Item struct {
+	ID            string `gorm:"primaryKey;size:50"`
+	Name          string `gorm:"size:200;not null"`
+	Type          string `gorm:"size:50"`
+	RequirementID string `gorm:"size:100"`
+	Developer     string `gorm:"size:100"`
+	Tester        string `gorm:"size:100"`
+	ItemOwner     string `gorm:"size:100"`
+	Status        string `gorm:"size:50"`
+	HasScript     bool
+	HasCache      bool
+	HasRestart    bool
+	BTEResult     string `gorm:"size:50"`
+	GrayResult    string `gorm:"size:50"`
+	ProdResult    string `gorm:"size:50"`
+	CloseReason   string `gorm:"size:500"`
+	VersionID     string `gorm:"size:50;index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (schemaV1Item) TableName() string { return "upgrade_items" }
+
+type schemaV1Version struct {
+	ID             string `gorm:"primaryKey;size:50"`
+	Name           string `gorm:"size:200;not null"`
+	VersionOwner   string `gorm:"size:100"`
+	VendorOwner    string `gorm:"size:100"`
+	BTETester      string `gorm:"size:100"`
+	GrayTester     string `gorm:"size:100"`
+	ProdTester     string `gorm:"size:100"`
+	IsUrgent       bool
+	UrgentReason   string `gorm:"size:500"`
+	Status         string `gorm:"size:50"`
+	CurrentStage   string `gorm:"size:50"`
+	ItemIDs        string `gorm:"type:text"`
+	FlowConfigID   uint
+	FlowRevisionID uint
+	WorkflowID     string `gorm:"size:100"`
+	Message        string `gorm:"size:500"`
+	CompletedAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (schemaV1Version) TableName() string { return "upgrade_versions" }
+
+type schemaV1Suspension struct {
+	ID          uint   `gorm:"primaryKey"`
+	VersionID   string `gorm:"size:50;index"`
+	ItemID      string `gorm:"size:50"`
+	Stage       string `gorm:"size:50"`
+	Reason      string `gorm:"size:500"`
+	Confirmed   bool
+	ConfirmedBy string `gorm:"size:100"`
+	ConfirmedAt *time.Time
+	CreatedAt   time.Time
+}
+
+func (schemaV1Suspension) TableName() string { return "upgrade_item_suspensions" }
+
+type schemaV1ItemTransition struct {
+	ID         uint   `gorm:"primaryKey"`
+	ItemID     string `gorm:"size:50;index"`
+	VersionID  string `gorm:"size:50"`
+	Stage      string `gorm:"size:50"`
+	FromStatus string `gorm:"size:50"`
+	ToStatus   string `gorm:"size:50"`
+	Operator   string `gorm:"size:100"`
+	Reason     string `gorm:"size:500"`
+	CreatedAt  time.Time
+}
+
+func (schemaV1ItemTransition) TableName() string { return "upgrade_item_transitions" }
+
+type schemaV1StageHistory struct {
+	ID          uint   `gorm:"primaryKey"`
+	VersionID   string `gorm:"size:50;index"`
+	Stage       string `gorm:"size:50"`
+	StageName   string `gorm:"size:100"`
+	Status      string `gorm:"size:20"`
+	Outcome     string `gorm:"size:20"`
+	Operator    string `gorm:"size:100"`
+	Message     string `gorm:"size:500"`
+	Iteration   int
+	StartedAt   time.Time
+	CompletedAt *time.Time
+}
+
+func (schemaV1StageHistory) TableName() string { return "upgrade_stage_history" }
+
+type schemaV1Audit struct {
+	ID         uint   `gorm:"primaryKey"`
+	VersionID  string `gorm:"size:50;uniqueIndex:idx_audit_event"`
+	Stage      string `gorm:"size:50;uniqueIndex:idx_audit_event"`
+	EventType  string `gorm:"size:20;uniqueIndex:idx_audit_event"`
+	ItemID     string `gorm:"size:50;uniqueIndex:idx_audit_event"`
+	Operator   string `gorm:"size:100"`
+	Passed     bool
+	Comment    string    `gorm:"size:500"`
+	OccurredAt time.Time `gorm:"uniqueIndex:idx_audit_event"`
+	CreatedAt  time.Time
+}
+
+func (schemaV1Audit) TableName() string { return "upgrade_audit_logs" }
+
+type schemaV1NotifyPreference struct {
+	User      string `gorm:"primaryKey;column:username;size:100"`
+	Channels  string `gorm:"size:200"`
+	Email     string `gorm:"size:200"`
+	Mobile    string `gorm:"size:50"`
+	UpdatedAt time.Time
+}
+
+func (schemaV1NotifyPreference) TableName() string { return "upgrade_notify_preferences" }
+
+type schemaV1Sequence struct {
+	Name      string `gorm:"primaryKey;size:150"`
+	Value     int64
+	UpdatedAt time.Time
+}
+
+func (schemaV1Sequence) TableName() string { return "upgrade_sequences" }
+
+// ---------- 迁移 5：条目测试用例和乐观锁版本号 ----------
+
+type schemaV5Item struct {
+	TestCases string `gorm:"type:text"`
+	Revision  int    `gorm:"not null;default:0"`
+}
+
+func (schemaV5Item) TableName() string { return "upgrade_items" }
+
+// ---------- 迁移 6：版本发布说明 ----------
+
+type schemaV6ReleaseNote struct {
+	VersionID string `gorm:"primaryKey;size:50"`
+	Markdown  string
+	HTML      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (schemaV6ReleaseNote) TableName() string { return "upgrade_release_notes" }
+
+// ---------- 迁移 7：审计记录去重包含操作人 ----------
+
+type schemaV7Audit struct {
+	VersionID  string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe"`
+	Stage      string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe"`
+	EventType  string    `gorm:"size:20;uniqueIndex:idx_audit_dedupe"`
+	ItemID     string    `gorm:"size:50;uniqueIndex:idx_audit_dedupe"`
+	Operator   string    `gorm:"size:100;uniqueIndex:idx_audit_dedupe"`
+	OccurredAt time.Time `gorm:"uniqueIndex:idx_audit_dedupe"`
+}
+
+func (schemaV7Audit) TableName() string { return "upgrade_audit_logs" }
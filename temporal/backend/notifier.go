@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 通知渠道
+// NotifyActivity 按接收人的渠道偏好把消息分组，每个渠道发送一次；
+// 发送失败时返回错误由 Activity 重试策略重试，已成功的渠道通过心跳记录不再重复发送
+// ============================================================
+
+// 通知渠道名称
+const (
+	ChannelLog      = "log"      // 写日志（默认）
+	ChannelMemory   = "memory"   // 内存，用于测试和流程模拟
+	ChannelWebhook  = "webhook"  // 通用 HTTP Webhook
+	ChannelDingTalk = "dingtalk" // 钉钉群机器人
+	ChannelWeCom    = "wecom"    // 企业微信群机器人
+	ChannelEmail    = "email"    // SMTP 邮件
+)
+
+var allChannels = []string{ChannelLog, ChannelMemory, ChannelWebhook, ChannelDingTalk, ChannelWeCom, ChannelEmail}
+
+// Recipient 通知接收人及其联系方式
+type Recipient struct {
+	User   string `json:"user"`
+	Email  string `json:"email,omitempty"`
+	Mobile string `json:"mobile,omitempty"`
+}
+
+// Message 发送到渠道的消息
+type Message struct {
+	Title      string      `json:"title"`
+	Content    string      `json:"content"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	notifiersMu     sync.RWMutex
+	notifiers       = map[string]Notifier{ChannelLog: LogNotifier{}, ChannelMemory: memorySink}
+	defaultChannels = []string{ChannelLog}
+
+	// memorySink 内存渠道，记录发送过的消息
+	memorySink = &MemoryNotifier{}
+)
+
+// RegisterNotifier 注册通知渠道，同名渠道会被替换
+func RegisterNotifier(channel string, notifier Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	notifiers[channel] = notifier
+}
+
+func getNotifier(channel string) (Notifier, bool) {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	notifier, ok := notifiers[channel]
+	return notifier, ok
+}
+
+// NotifyConfig 通知渠道配置
+type NotifyConfig struct {
+	DefaultChannels []string
+	Webhook         *WebhookNotifier
+	DingTalk        *WebhookNotifier
+	WeCom           *WebhookNotifier
+	SMTP            *SMTPNotifier
+}
+
+// initNotifiers 按配置注册通知渠道
+func initNotifiers(cfg NotifyConfig) {
+	if len(cfg.DefaultChannels) > 0 {
+		defaultChannels = cfg.DefaultChannels
+	}
+	if cfg.Webhook != nil {
+		RegisterNotifier(ChannelWebhook, cfg.Webhook)
+	}
+	if cfg.DingTalk != nil {
+		RegisterNotifier(ChannelDingTalk, cfg.DingTalk)
+	}
+	if cfg.WeCom != nil {
+		RegisterNotifier(ChannelWeCom, cfg.WeCom)
+	}
+	if cfg.SMTP != nil {
+		RegisterNotifier(ChannelEmail, cfg.SMTP)
+	}
+	logger.Info("通知渠道已初始化", zap.Strings("defaultChannels", defaultChannels))
+}
+
+// ============================================================
+// 日志 / 内存
+// ============================================================
+
+// LogNotifier 把消息写入日志
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, msg Message) error {
+	users := make([]string, 0, len(msg.Recipients))
+	for _, r := range msg.Recipients {
+		users = append(users, r.User)
+	}
+	logger.Info("发送通知",
+		zap.String("title", msg.Title),
+		zap.Strings("recipients", users),
+		zap.String("content", msg.Content))
+	return nil
+}
+
+// MemoryNotifier 把消息保存在内存中
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryNotifier) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages 已发送的消息
+func (m *MemoryNotifier) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset 清空已发送的消息
+func (m *MemoryNotifier) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+// ============================================================
+// Webhook
+// ============================================================
+
+// WebhookNotifier HTTP Webhook 渠道
+//
+//	dingtalk: 钉钉机器人消息格式，配置 Secret 时按加签方式在 URL 上附加 timestamp/sign
+//	wecom:    企业微信机器人消息格式，密钥包含在 URL 的 key 参数中
+//	webhook:  通用 JSON，配置 Secret 时在 X-Signature 头中附加请求体的 HMAC-SHA256 签名
+type WebhookNotifier struct {
+	Kind   string
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Send(ctx context.Context, msg Message) error {
+	target := w.URL
+	var mobiles, users []string
+	for _, r := range msg.Recipients {
+		users = append(users, r.User)
+		if r.Mobile != "" {
+			mobiles = append(mobiles, r.Mobile)
+		}
+	}
+	text := msg.Title + "\n" + msg.Content
+
+	var payload interface{}
+	switch w.Kind {
+	case ChannelDingTalk:
+		payload = gin.H{
+			"msgtype": "text",
+			"text":    gin.H{"content": text},
+			"at":      gin.H{"atMobiles": mobiles},
+		}
+		if w.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			sign := hmacBase64(w.Secret, timestamp+"\n"+w.Secret)
+			target = appendQuery(target, url.Values{"timestamp": {timestamp}, "sign": {sign}})
+		}
+	case ChannelWeCom:
+		payload = gin.H{
+			"msgtype": "text",
+			"text":    gin.H{"content": text, "mentioned_mobile_list": mobiles},
+		}
+	default:
+		payload = gin.H{
+			"title":      msg.Title,
+			"content":    msg.Content,
+			"recipients": users,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Kind == ChannelWebhook && w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", hmacBase64(w.Secret, timestamp+"\n"+string(body)))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s 返回 HTTP %d", w.Kind, resp.StatusCode)
+	}
+	if w.Kind == ChannelDingTalk || w.Kind == ChannelWeCom {
+		// 机器人接口出错时仍返回 200，错误码在响应体中
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		if result.ErrCode != 0 {
+			return fmt.Errorf("%s 返回错误 %d: %s", w.Kind, result.ErrCode, result.ErrMsg)
+		}
+	}
+	return nil
+}
+
+func hmacBase64(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func appendQuery(rawURL string, values url.Values) string {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return rawURL + sep + values.Encode()
+}
+
+// ============================================================
+// SMTP 邮件
+// ============================================================
+
+// SMTPNotifier SMTP 邮件渠道，一条消息发送一封邮件给所有有邮箱的接收人
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	var to []string
+	for _, r := range msg.Recipients {
+		if r.Email != "" {
+			to = append(to, r.Email)
+		}
+	}
+	if len(to) == 0 {
+		logger.Warn("接收人未设置邮箱，跳过邮件通知", zap.String("title", msg.Title))
+		return nil
+	}
+
+	port := s.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + s.From + "\r\n")
+	body.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	body.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Title) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(msg.Content)
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", s.Host, port), auth, s.From, to, []byte(body.String()))
+}
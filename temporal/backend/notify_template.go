@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 通知模板
+// 阶段配置的 templates 按通知事件配置模板，使用 text/template 语法，可用占位符：
+//   {{.Version.ID}} {{.Version.Name}} {{.Version.VersionOwner}} {{.Version.IsUrgent}} 等版本字段
+//   {{.StageName}} {{.Stage}}       阶段名称/标识
+//   {{range .Items}}{{.ID}} {{.Name}}{{end}} {{len .Items}}  版本条目
+//   {{.Recipients}}                 接收人
+//   {{.Message}}                    系统默认消息
+//   {{.Vars.xxx}}                   事件附加变量，如 remaining、return_from、count
+// 未配置模板时使用默认消息
+// ============================================================
+
+// 通知事件
+const (
+	NotifyEventEnter    = "enter"    // 进入阶段
+	NotifyEventRemind   = "remind"   // 超时提醒
+	NotifyEventEscalate = "escalate" // 超时升级
+	NotifyEventReturn   = "return"   // 退回到该阶段
+	NotifyEventSuspend  = "suspend"  // 挂起不通过条目
+)
+
+// 版本事件，不属于某个阶段，使用默认消息
+const (
+	NotifyEventCancel = "cancel" // 版本取消
+	NotifyEventPause  = "pause"  // 版本暂停
+	NotifyEventResume = "resume" // 版本恢复
+)
+
+var notifyEvents = []string{NotifyEventEnter, NotifyEventRemind, NotifyEventEscalate, NotifyEventReturn, NotifyEventSuspend}
+
+// NotifyTemplateData 模板数据
+type NotifyTemplateData struct {
+	Version    UpgradeVersion
+	Stage      string
+	StageName  string
+	Items      []UpgradeItem
+	Recipients []string
+	Message    string
+	Vars       map[string]string
+}
+
+// renderNotification 渲染通知标题和内容，模板出错时使用默认消息
+func renderNotification(n Notification) (string, string) {
+	data := loadTemplateData(n)
+	title := fmt.Sprintf("【升级流程】%s", data.Version.Name)
+	if data.Version.IsUrgent {
+		title = urgentTitlePrefix + title
+	}
+	if n.StageName != "" {
+		title += " - " + n.StageName
+	}
+	if n.Template == "" {
+		return title, n.Message
+	}
+
+	content, err := executeTemplate(n.Template, data)
+	if err != nil {
+		logger.Warn("通知模板渲染失败，使用默认消息",
+			zap.String("versionId", n.VersionID),
+			zap.String("stage", n.Stage),
+			zap.String("event", n.Event),
+			zap.Error(err))
+		return title, n.Message
+	}
+	return title, content
+}
+
+func executeTemplate(text string, data NotifyTemplateData) (string, error) {
+	tmpl, err := template.New("notify").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// loadTemplateData 加载版本和条目信息，查询失败时只填充通知本身的字段
+func loadTemplateData(n Notification) NotifyTemplateData {
+	data := NotifyTemplateData{
+		Version:    UpgradeVersion{ID: n.VersionID, Name: n.VersionID},
+		Stage:      n.Stage,
+		StageName:  n.StageName,
+		Recipients: n.Recipients,
+		Message:    n.Message,
+		Vars:       n.Vars,
+	}
+
+	version, err := store.GetVersionByID(n.VersionID)
+	if err != nil {
+		return data
+	}
+	data.Version = toUpgradeVersion(version)
+
+	var itemIDs []string
+	json.Unmarshal([]byte(version.ItemIDs), &itemIDs)
+	for _, itemID := range itemIDs {
+		if item, err := store.GetItemByID(itemID); err == nil {
+			data.Items = append(data.Items, toUpgradeItem(item))
+		}
+	}
+	return data
+}
+
+// validateTemplates 校验阶段通知模板
+func validateTemplates(v *flowValidator, field string, stage StageConfig) {
+	events := make([]string, 0, len(stage.Templates))
+	for event := range stage.Templates {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	for _, event := range events {
+		if !containsKey(notifyEvents, event) {
+			v.add(field+".templates."+event, "通知事件 %s 无效", event)
+			continue
+		}
+		if _, err := template.New(event).Parse(stage.Templates[event]); err != nil {
+			v.add(field+".templates."+event, "通知模板无效: %v", err)
+		}
+	}
+}
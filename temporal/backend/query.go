@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// 列表查询
+// 条目和版本列表支持筛选、名称搜索、排序和游标分页：
+//   游标记录上一页最后一条的排序值和 ID，翻页时数据变化不会重复或遗漏
+//   游标与排序方式绑定，更换排序后需要从第一页开始
+//   total 为符合筛选条件的总数，不受分页影响
+// 查询参数：
+//   q                       名称包含（不区分大小写）
+//   created_from/created_to 创建日期范围，2006-01-02（包含当天）或 RFC3339 时间
+//   sort/order              排序字段和方向（asc/desc），默认按创建时间倒序；版本列表中紧急版本总是排在前面
+//   cursor/limit            上一页返回的 next_cursor 和每页数量
+//   status                  多个状态用逗号分隔
+// ============================================================
+
+// 分页数量
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// sortFields 可以排序的字段，条目和版本相同
+var sortFields = []string{"created_at", "updated_at", "name", "id"}
+
+var errInvalidCursor = errors.New("cursor 无效，请从第一页重新查询")
+
+// ListParams 条目和版本共用的搜索、日期范围、排序和分页参数
+type ListParams struct {
+	Search      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time // 不包含
+	Sort        string
+	Desc        bool
+	Cursor      *pageCursor
+	Limit       int
+}
+
+// ItemQuery 条目列表查询条件，字符串条件为空时不筛选
+type ItemQuery struct {
+	ListParams
+	Statuses      []string
+	Type          string
+	Developer     string
+	Tester        string
+	ItemOwner     string
+	RequirementID string
+}
+
+// VersionQuery 版本列表查询条件，字符串条件为空时不筛选
+type VersionQuery struct {
+	ListParams
+	Statuses     []string
+	CurrentStage string
+	IsUrgent     *bool
+	VersionOwner string
+	Tester       string // BTE、灰度、生产任一测试人员
+}
+
+// ItemPage 一页条目
+type ItemPage struct {
+	Items      []ItemModel `json:"items"`
+	Total      int64       `json:"total"`
+	NextCursor string      `json:"next_cursor"` // 为空表示没有下一页
+}
+
+// VersionPage 一页版本
+type VersionPage struct {
+	Versions   []VersionModel `json:"versions"`
+	Total      int64          `json:"total"`
+	NextCursor string         `json:"next_cursor"` // 为空表示没有下一页
+}
+
+// pageCursor 分页游标，编码后返回给前端
+type pageCursor struct {
+	Sort   string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`           // 上一页最后一条的排序值
+	ID     string `json:"i"`           // 上一页最后一条的 ID，排序值相同时按 ID 排序
+	Urgent *bool  `json:"u,omitempty"` // 上一页最后一条是否紧急，只用于版本列表
+}
+
+func (p pageCursor) encode() string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// ============================================================
+// 查询参数解析
+// ============================================================
+
+// parseItemQuery 解析条目列表的查询参数
+func parseItemQuery(c *gin.Context) (ItemQuery, error) {
+	params, err := parseListParams(c)
+	if err != nil {
+		return ItemQuery{}, err
+	}
+	if params.Cursor != nil && params.Cursor.Urgent != nil {
+		return ItemQuery{}, errInvalidCursor
+	}
+	return ItemQuery{
+		ListParams:    params,
+		Statuses:      splitList(c.Query("status")),
+		Type:          c.Query("type"),
+		Developer:     c.Query("developer"),
+		Tester:        c.Query("tester"),
+		ItemOwner:     c.Query("item_owner"),
+		RequirementID: c.Query("requirement_id"),
+	}, nil
+}
+
+// parseVersionQuery 解析版本列表的查询参数
+func parseVersionQuery(c *gin.Context) (VersionQuery, error) {
+	params, err := parseListParams(c)
+	if err != nil {
+		return VersionQuery{}, err
+	}
+	if params.Cursor != nil && params.Cursor.Urgent == nil {
+		return VersionQuery{}, errInvalidCursor
+	}
+	q := VersionQuery{
+		ListParams:   params,
+		Statuses:     splitList(c.Query("status")),
+		CurrentStage: c.Query("current_stage"),
+		VersionOwner: c.Query("version_owner"),
+		Tester:       c.Query("tester"),
+	}
+	if v := c.Query("is_urgent"); v != "" {
+		urgent, err := strconv.ParseBool(v)
+		if err != nil {
+			return VersionQuery{}, fmt.Errorf("is_urgent 无效: %s", v)
+		}
+		q.IsUrgent = &urgent
+	}
+	return q, nil
+}
+
+func parseListParams(c *gin.Context) (ListParams, error) {
+	p := ListParams{
+		Search: strings.TrimSpace(c.Query("q")),
+		Sort:   c.DefaultQuery("sort", "created_at"),
+		Desc:   true,
+		Limit:  defaultPageLimit,
+	}
+	if !containsKey(sortFields, p.Sort) {
+		return p, fmt.Errorf("sort 无效，可选 %s", strings.Join(sortFields, "/"))
+	}
+	switch order := c.Query("order"); order {
+	case "", "desc":
+	case "asc":
+		p.Desc = false
+	default:
+		return p, fmt.Errorf("order 无效: %s，可选 asc/desc", order)
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, fmt.Errorf("limit 无效，应为 1-%d", maxPageLimit)
+		}
+		p.Limit = limit
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return p, err
+		}
+		if cursor.Sort != p.Sort || cursor.Desc != p.Desc {
+			return p, errInvalidCursor
+		}
+		if _, err := cursorValue(p.Sort, cursor.Value); err != nil {
+			return p, errInvalidCursor
+		}
+		p.Cursor = cursor
+	}
+
+	var err error
+	if p.CreatedFrom, err = parseDateParam(c.Query("created_from"), false); err != nil {
+		return p, fmt.Errorf("created_from 无效: %w", err)
+	}
+	if p.CreatedTo, err = parseDateParam(c.Query("created_to"), true); err != nil {
+		return p, fmt.Errorf("created_to 无效: %w", err)
+	}
+	return p, nil
+}
+
+// parseDateParam 解析日期或时间，end 为 true 时日期表示当天结束
+func parseDateParam(v string, end bool) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("应为 2006-01-02 或 RFC3339 时间: %s", v)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// splitList 拆分逗号分隔的参数
+func splitList(v string) []string {
+	var values []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// ============================================================
+// 查询构造
+// ============================================================
+
+// filter 应用搜索和日期范围条件
+func (p ListParams) filter(query *gorm.DB) *gorm.DB {
+	if p.Search != "" {
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(p.Search))+"%")
+	}
+	if p.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *p.CreatedFrom)
+	}
+	if p.CreatedTo != nil {
+		query = query.Where("created_at < ?", *p.CreatedTo)
+	}
+	return query
+}
+
+// page 应用游标、排序和数量，多取一条用于判断是否有下一页
+func (p ListParams) page(query *gorm.DB) *gorm.DB {
+	if p.Cursor != nil {
+		cond, args := p.afterCursor()
+		query = query.Where(cond, args...)
+	}
+	return p.order(query)
+}
+
+// urgentPage 版本列表的分页，紧急版本排在前面，同为紧急或不紧急时按排序字段
+func (p ListParams) urgentPage(query *gorm.DB) *gorm.DB {
+	if p.Cursor != nil {
+		urgent := *p.Cursor.Urgent
+		cond, args := p.afterCursor()
+		query = query.Where("(is_urgent < ? OR (is_urgent = ? AND "+cond+"))", append([]interface{}{urgent, urgent}, args...)...)
+	}
+	return p.order(query.Order("is_urgent DESC"))
+}
+
+// afterCursor 排序在游标之后的条件
+func (p ListParams) afterCursor() (string, []interface{}) {
+	op := ">"
+	if p.Desc {
+		op = "<"
+	}
+	value, _ := cursorValue(p.Sort, p.Cursor.Value)
+	return fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", p.Sort, op, p.Sort, op), []interface{}{value, value, p.Cursor.ID}
+}
+
+// order 按排序字段和 ID 排序，多取一条用于判断是否有下一页
+func (p ListParams) order(query *gorm.DB) *gorm.DB {
+	dir := "ASC"
+	if p.Desc {
+		dir = "DESC"
+	}
+	return query.Order(p.Sort + " " + dir).Order("id " + dir).Limit(p.Limit + 1)
+}
+
+// nextCursor 下一页的游标，value 和 id 为本页最后一条的排序值和 ID
+func (p ListParams) nextCursor(value, id string) string {
+	return pageCursor{Sort: p.Sort, Desc: p.Desc, Value: value, ID: id}.encode()
+}
+
+// nextUrgentCursor 版本列表下一页的游标，同时记录本页最后一条是否紧急
+func (p ListParams) nextUrgentCursor(urgent bool, value, id string) string {
+	return pageCursor{Sort: p.Sort, Desc: p.Desc, Value: value, ID: id, Urgent: &urgent}.encode()
+}
+
+// sortValue 记录的排序值，时间使用 RFC3339Nano 保留精度
+func sortValue(sort, id, name string, createdAt, updatedAt time.Time) string {
+	switch sort {
+	case "created_at":
+		return createdAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return updatedAt.Format(time.RFC3339Nano)
+	case "name":
+		return name
+	}
+	return id
+}
+
+// cursorValue 把游标中的排序值转换为查询参数
+func cursorValue(sort, value string) (interface{}, error) {
+	switch sort {
+	case "created_at", "updated_at":
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return value, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestListVersionsUrgentFirst 紧急版本排在前面，按游标翻页不重复不遗漏
+func TestListVersionsUrgentFirst(t *testing.T) {
+	s := newMigratedStore(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	urgent := map[string]bool{"V2": true, "V5": true}
+	for i := 1; i <= 6; i++ {
+		id := fmt.Sprintf("V%d", i)
+		version := VersionModel{ID: id, Name: "版本" + id, Status: "running", IsUrgent: urgent[id], ItemIDs: `[]`,
+			CreatedAt: base.Add(time.Duration(i) * time.Hour)}
+		if _, err := s.CreateVersionWithItems(&version, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		desc bool
+		want []string
+	}{
+		{true, []string{"V5", "V2", "V6", "V4", "V3", "V1"}},
+		{false, []string{"V2", "V5", "V1", "V3", "V4", "V6"}},
+	}
+	for _, tc := range cases {
+		q := VersionQuery{ListParams: ListParams{Sort: "created_at", Desc: tc.desc, Limit: 2}}
+		var got []string
+		for {
+			page, err := s.ListVersions(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range page.Versions {
+				got = append(got, v.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			if q.Cursor, err = decodeCursor(page.NextCursor); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("desc=%v 翻页结果 %v，期望 %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+// TestParseVersionQueryRejectsItemCursor 版本列表不接受没有紧急标记的游标
+func TestParseVersionQueryRejectsItemCursor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cursor := pageCursor{Sort: "created_at", Desc: true, Value: time.Now().Format(time.RFC3339Nano), ID: "I1"}.encode()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/versions?cursor="+cursor, nil)
+	if _, err := parseVersionQuery(c); err != errInvalidCursor {
+		t.Fatalf("没有紧急标记的游标应无效: %v", err)
+	}
+	c.Request = httptest.NewRequest("GET", "/api/items?cursor="+cursor, nil)
+	if _, err := parseItemQuery(c); err != nil {
+		t.Fatalf("条目列表游标: %v", err)
+	}
+}
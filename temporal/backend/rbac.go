@@ -0,0 +1,181 @@
+package main
+
+import (
+	_ "embed"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// ============================================================
+// 阶段操作权限
+// casbin RBAC with domains，域为版本编号：
+//   g, 操作人, 角色, 版本编号
+//   p, 角色, 操作, 阶段, 版本编号
+// ============================================================
+
+//go:embed rbac_model.conf
+var rbacModel string
+
+// 版本角色
+const (
+	RoleVersionOwner = "version_owner" // 版本负责人
+	RoleVendorOwner  = "vendor_owner"  // 厂家负责人
+	RoleBTETester    = "bte_tester"    // BTE测试负责人
+	RoleGrayTester   = "gray_tester"   // 灰度测试负责人
+	RoleProdTester   = "prod_tester"   // 生产测试负责人
+)
+
+var allRoles = []string{RoleVersionOwner, RoleVendorOwner, RoleBTETester, RoleGrayTester, RoleProdTester}
+
+// 操作
+const (
+	ActApprove = "approve" // 审批/准备阶段确认
+	ActTest    = "test"    // 提交测试结果
+	ActConfirm = "confirm" // 确认挂起等决定
+	ActControl = "control" // 取消/暂停/恢复版本
+)
+
+// 非阶段资源名
+const (
+	ObjSuspension = "suspension" // 挂起确认
+	ObjVersion    = "version"    // 版本本身
+)
+
+var (
+	enforcer      *casbin.SyncedEnforcer
+	loadedMu      sync.Mutex
+	loadedDomains = make(map[string]bool)
+)
+
+func initEnforcer() error {
+	m, err := model.NewModelFromString(rbacModel)
+	if err != nil {
+		return err
+	}
+	enforcer, err = casbin.NewSyncedEnforcer(m)
+	return err
+}
+
+// stageRoles 阶段可操作的角色，未配置时按阶段默认
+func stageRoles(stage StageConfig) []string {
+	if len(stage.Roles) > 0 {
+		return stage.Roles
+	}
+	switch stage.Key {
+	case StageBTEPrepare, StageGrayPrepare, StageProdPrepare:
+		return []string{RoleVendorOwner}
+	case StageBTETest:
+		return []string{RoleBTETester}
+	case StageGrayTest:
+		return []string{RoleGrayTester}
+	case StageProdTest:
+		return []string{RoleProdTester}
+	}
+	return []string{RoleVersionOwner}
+}
+
+// roleMember 版本中担任该角色的人员
+func roleMember(role string, version UpgradeVersion) string {
+	switch role {
+	case RoleVersionOwner:
+		return version.VersionOwner
+	case RoleVendorOwner:
+		return version.VendorOwner
+	case RoleBTETester:
+		return version.BTETester
+	case RoleGrayTester:
+		return version.GrayTester
+	case RoleProdTester:
+		return version.ProdTester
+	}
+	return ""
+}
+
+// stageAct 阶段类型对应的操作
+func stageAct(stageType string) string {
+	if stageType == "test" {
+		return ActTest
+	}
+	return ActApprove
+}
+
+// loadVersionPolicies 加载版本的人员角色和阶段权限
+func loadVersionPolicies(version UpgradeVersion, stages []StageConfig) error {
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+
+	domain := version.ID
+	if loadedDomains[domain] {
+		return nil
+	}
+
+	for _, role := range allRoles {
+		if member := roleMember(role, version); member != "" {
+			if _, err := enforcer.AddGroupingPolicy(member, role, domain); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := enforcer.AddPolicy(RoleVersionOwner, ActConfirm, ObjSuspension, domain); err != nil {
+		return err
+	}
+	if _, err := enforcer.AddPolicy(RoleVersionOwner, ActControl, ObjVersion, domain); err != nil {
+		return err
+	}
+	for _, stage := range stages {
+		for _, role := range stageRoles(stage) {
+			if _, err := enforcer.AddPolicy(role, stageAct(stage.Type), stage.Key, domain); err != nil {
+				return err
+			}
+		}
+	}
+
+	loadedDomains[domain] = true
+	return nil
+}
+
+// escalatedTo 阶段审批是否已超时升级到该操作人
+// 升级以审计记录为准，在校验时读取：Worker 写入的升级对已缓存版本权限的 serve 进程同样生效
+func escalatedTo(versionID, stage, operator string) (bool, error) {
+	audits, err := store.GetAudits(versionID, stage)
+	if err != nil {
+		return false, err
+	}
+	for _, audit := range audits {
+		if audit.EventType == AuditEscalate && audit.Operator == operator {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckPermission 校验操作人能否对版本执行操作
+func CheckPermission(versionID, operator, act, obj string) (bool, error) {
+	loadedMu.Lock()
+	loaded := loadedDomains[versionID]
+	loadedMu.Unlock()
+
+	if !loaded {
+		version, err := store.GetVersionByID(versionID)
+		if err != nil {
+			return false, err
+		}
+		stages, err := LoadVersionStages(version)
+		if err != nil {
+			return false, err
+		}
+		if err := loadVersionPolicies(toUpgradeVersion(version), stages); err != nil {
+			return false, err
+		}
+	}
+
+	ok, err := enforcer.Enforce(operator, act, obj, versionID)
+	if err != nil || ok || act != ActApprove {
+		return ok, err
+	}
+	// 超时升级过的阶段由升级对象审批
+	return escalatedTo(versionID, obj, operator)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// resetEnforcer 创建新的权限校验器，模拟新启动的进程
+func resetEnforcer(t *testing.T) {
+	t.Helper()
+	if err := initEnforcer(); err != nil {
+		t.Fatal(err)
+	}
+	loadedMu.Lock()
+	loadedDomains = make(map[string]bool)
+	loadedMu.Unlock()
+}
+
+// TestEscalationVisibleToOtherProcess Worker 写入的升级对已缓存版本权限的 serve 进程生效
+func TestEscalationVisibleToOtherProcess(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	version := VersionModel{ID: "V-ESC", Name: "版本", VersionOwner: "owner", VendorOwner: "vendor", Status: "running", ItemIDs: `[]`}
+	if _, err := s.CreateVersionWithItems(&version, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// serve 进程：加载并缓存版本权限，版本负责人不能审批准备阶段
+	resetEnforcer(t)
+	if ok, err := CheckPermission("V-ESC", "owner", ActApprove, StageBTEPrepare); err != nil || ok {
+		t.Fatalf("升级前版本负责人审批准备阶段: %v, %v", ok, err)
+	}
+	apiEnforcer, apiDomains := enforcer, loadedDomains
+
+	// worker 进程：记录升级审计并执行升级 Activity
+	resetEnforcer(t)
+	req := EscalateStageRequest{VersionID: "V-ESC", Stage: StageBTEPrepare, To: "owner"}
+	if err := EscalateStageActivity(context.Background(), req); err == nil {
+		t.Fatal("升级审计未保存时应返回错误")
+	}
+	if err := s.CreateAudit(AuditEvent{VersionID: "V-ESC", Stage: StageBTEPrepare, EventType: AuditEscalate, Operator: "owner", OccurredAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EscalateStageActivity(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	// 回到 serve 进程
+	enforcer, loadedDomains = apiEnforcer, apiDomains
+	if ok, err := CheckPermission("V-ESC", "owner", ActApprove, StageBTEPrepare); err != nil || !ok {
+		t.Fatalf("升级后版本负责人应能审批准备阶段: %v, %v", ok, err)
+	}
+	if ok, _ := CheckPermission("V-ESC", "owner", ActApprove, StageGrayPrepare); ok {
+		t.Fatal("未升级的阶段不应授权")
+	}
+	if ok, _ := CheckPermission("V-ESC", "vendor", ActApprove, StageBTEPrepare); !ok {
+		t.Fatal("升级后阶段原有角色仍可审批")
+	}
+	if ok, _ := CheckPermission("V-ESC", "other", ActApprove, StageBTEPrepare); ok {
+		t.Fatal("升级只授权给升级对象")
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// 发布说明
+// 版本完成时由 ArchiveKnowledgeActivity 生成 Markdown 和 HTML 两种格式并保存：
+//   条目按类型（需求/BUG/优化）分组，列出开发、测试人员和各测试阶段的结果
+//   有脚本、涉及缓存、需要重启的条目在上线注意事项中单独列出
+//   流程中挂起的条目不在版本中，列出挂起阶段和原因
+// HTML 由 html/template 渲染，条目内容全部转义；Markdown 中的标记字符同样转义，表格不会被内容打乱
+// 下载：GET /api/versions/:versionId/release-notes?format=md|html
+// ============================================================
+
+// 发布说明格式，同时作为文件扩展名
+const (
+	ReleaseNoteMarkdown = "md"
+	ReleaseNoteHTML     = "html"
+)
+
+// releaseOutcomeNames 测试阶段结束方式的显示名称
+var releaseOutcomeNames = map[string]string{
+	StageOutcomePassed:   "通过",
+	StageOutcomeFailed:   "不通过",
+	StageOutcomeTimeout:  "超时",
+	StageOutcomeApproved: "通过",
+	StageOutcomeAutoPass: "自动通过",
+	StageOutcomeRejected: "驳回",
+}
+
+// ReleaseNoteData 发布说明的内容，Markdown 和 HTML 模板共用
+type ReleaseNoteData struct {
+	Version     UpgradeVersion
+	GeneratedAt string
+	ItemCount   int
+	Developers  []string
+	Testers     []string
+	Stages      []ReleaseStage // 执行过的测试阶段，按开始时间排序
+	Groups      []ReleaseGroup // 按类型分组的条目
+	Scripts     []UpgradeItem  // 有脚本的条目
+	Caches      []UpgradeItem  // 涉及缓存的条目
+	Restarts    []UpgradeItem  // 需要重启的条目
+	Suspensions []ReleaseSuspension
+}
+
+// ReleaseStage 测试阶段最后一次执行的结果
+type ReleaseStage struct {
+	Key         string
+	Name        string
+	Outcome     string
+	Operator    string
+	CompletedAt string
+	Runs        int // 执行次数，退回后重新测试时大于 1
+}
+
+// ReleaseGroup 同一类型的条目
+type ReleaseGroup struct {
+	Type  string
+	Items []ReleaseItem
+}
+
+// ReleaseItem 条目和各测试阶段的结果，Results 与 ReleaseNoteData.Stages 对应
+type ReleaseItem struct {
+	UpgradeItem
+	Results []string
+}
+
+// Notes 上线注意事项，如"脚本、重启"
+func (item ReleaseItem) Notes() string {
+	var notes []string
+	if item.HasScript {
+		notes = append(notes, "脚本")
+	}
+	if item.HasCache {
+		notes = append(notes, "缓存")
+	}
+	if item.HasRestart {
+		notes = append(notes, "重启")
+	}
+	return strings.Join(notes, "、")
+}
+
+// ReleaseSuspension 流程中挂起的条目
+type ReleaseSuspension struct {
+	ItemID    string
+	ItemName  string
+	StageName string
+	Reason    string
+}
+
+// ArchiveKnowledgeActivity 知识沉淀 Activity，生成并保存版本发布说明
+func ArchiveKnowledgeActivity(ctx context.Context, versionID string) error {
+	data, err := loadReleaseNoteData(versionID, time.Now())
+	if err != nil {
+		return err
+	}
+	note, err := renderReleaseNote(data)
+	if err != nil {
+		return err
+	}
+	if err := store.SaveReleaseNote(note); err != nil {
+		return err
+	}
+	logger.Info("发布说明已生成", zap.String("versionId", versionID), zap.Int("items", data.ItemCount))
+	return nil
+}
+
+// loadReleaseNoteData 加载版本、条目、阶段历史和挂起记录
+func loadReleaseNoteData(versionID string, now time.Time) (ReleaseNoteData, error) {
+	version, err := store.GetVersionByID(versionID)
+	if err != nil {
+		return ReleaseNoteData{}, err
+	}
+	history, err := store.GetStageHistory(versionID)
+	if err != nil {
+		return ReleaseNoteData{}, err
+	}
+	suspensions, err := store.GetSuspensionsByVersion(versionID)
+	if err != nil {
+		return ReleaseNoteData{}, err
+	}
+
+	var items []UpgradeItem
+	var itemIDs []string
+	if err := json.Unmarshal([]byte(version.ItemIDs), &itemIDs); err != nil {
+		return ReleaseNoteData{}, fmt.Errorf("版本 %s 的条目列表无效: %w", versionID, err)
+	}
+	for _, itemID := range itemIDs {
+		item, err := store.GetItemByID(itemID)
+		if err != nil {
+			return ReleaseNoteData{}, fmt.Errorf("条目 %s: %w", itemID, err)
+		}
+		items = append(items, toUpgradeItem(item))
+	}
+
+	stages := releaseStages(history)
+	data := ReleaseNoteData{
+		Version:     toUpgradeVersion(version),
+		GeneratedAt: now.Format("2006-01-02 15:04:05"),
+		ItemCount:   len(items),
+		Stages:      stages,
+		Groups:      groupReleaseItems(items, stages),
+	}
+
+	var developers, testers []string
+	for _, item := range items {
+		developers = append(developers, item.Developer)
+		testers = append(testers, item.Tester)
+		if item.HasScript {
+			data.Scripts = append(data.Scripts, item)
+		}
+		if item.HasCache {
+			data.Caches = append(data.Caches, item)
+		}
+		if item.HasRestart {
+			data.Restarts = append(data.Restarts, item)
+		}
+	}
+	data.Developers = uniqueSorted(developers)
+	data.Testers = uniqueSorted(testers)
+
+	stageNames := make(map[string]string)
+	for _, h := range history {
+		stageNames[h.Stage] = h.StageName
+	}
+	for _, s := range suspensions {
+		suspension := ReleaseSuspension{ItemID: s.ItemID, StageName: stageNames[s.Stage], Reason: s.Reason}
+		if suspension.StageName == "" {
+			suspension.StageName = s.Stage
+		}
+		if item, err := store.GetItemByID(s.ItemID); err == nil {
+			suspension.ItemName = item.Name
+		} else {
+			logger.Warn("发布说明查询挂起条目失败", zap.String("versionId", versionID), zap.String("itemId", s.ItemID), zap.Error(err))
+		}
+		data.Suspensions = append(data.Suspensions, suspension)
+	}
+	return data, nil
+}
+
+// releaseStages 从阶段历史中取出测试阶段，退回后重新执行的阶段以最后一次为准
+func releaseStages(history []StageHistoryModel) []ReleaseStage {
+	var stages []ReleaseStage
+	index := make(map[string]int)
+	for _, h := range history {
+		if testResultColumn(h.Stage) == "" {
+			continue
+		}
+		i, ok := index[h.Stage]
+		if !ok {
+			i = len(stages)
+			index[h.Stage] = i
+			stages = append(stages, ReleaseStage{Key: h.Stage})
+		}
+		stage := &stages[i]
+		stage.Name = h.StageName
+		stage.Outcome = releaseOutcomeNames[h.Outcome]
+		if stage.Outcome == "" {
+			stage.Outcome = h.Outcome
+		}
+		stage.Operator = h.Operator
+		stage.CompletedAt = ""
+		if h.CompletedAt != nil {
+			stage.CompletedAt = h.CompletedAt.Format("2006-01-02 15:04:05")
+		}
+		stage.Runs++
+	}
+	return stages
+}
+
+// groupReleaseItems 按类型分组，itemTypes 中的类型在前，组内保持版本中的顺序
+func groupReleaseItems(items []UpgradeItem, stages []ReleaseStage) []ReleaseGroup {
+	byType := make(map[string][]ReleaseItem)
+	var types []string
+	for _, item := range items {
+		if _, ok := byType[item.Type]; !ok {
+			types = append(types, item.Type)
+		}
+		results := make([]string, len(stages))
+		for i, stage := range stages {
+			results[i] = itemTestResult(item, stage.Key)
+		}
+		byType[item.Type] = append(byType[item.Type], ReleaseItem{UpgradeItem: item, Results: results})
+	}
+
+	rank := func(t string) int {
+		for i, known := range itemTypes {
+			if t == known {
+				return i
+			}
+		}
+		return len(itemTypes)
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		if rank(types[i]) != rank(types[j]) {
+			return rank(types[i]) < rank(types[j])
+		}
+		return types[i] < types[j]
+	})
+
+	groups := make([]ReleaseGroup, 0, len(types))
+	for _, t := range types {
+		groups = append(groups, ReleaseGroup{Type: t, Items: byType[t]})
+	}
+	return groups
+}
+
+// itemTestResult 条目在测试阶段的结果
+func itemTestResult(item UpgradeItem, stage string) string {
+	switch stage {
+	case StageBTETest:
+		return item.BTEResult
+	case StageGrayTest:
+		return item.GrayResult
+	case StageProdTest:
+		return item.ProdResult
+	}
+	return ""
+}
+
+// uniqueSorted 去掉空值和重复值并排序
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ============================================================
+// 渲染
+// ============================================================
+
+// markdownEscaper 转义 Markdown 标记字符，换行合并为空格以免打断列表和表格
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]",
+	"<", "\\<", ">", "\\>", "|", "\\|", "#", "\\#", "\r\n", " ", "\n", " ", "\r", " ",
+)
+
+// markdownText 转义后的文本，空值显示为 -
+func markdownText(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return "-"
+	}
+	return markdownEscaper.Replace(s)
+}
+
+// textOrDash 空值显示为 -，HTML 模板中使用，转义由 html/template 完成
+func textOrDash(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return "-"
+	}
+	return s
+}
+
+var releaseNoteFuncs = map[string]interface{}{
+	"md":   markdownText,
+	"text": textOrDash,
+	"join": strings.Join,
+}
+
+var releaseNoteMarkdown = template.Must(template.New("markdown").Funcs(releaseNoteFuncs).Parse(
+	`# {{md .Version.Name}} 发布说明
+
+- 版本ID：{{md .Version.ID}}
+{{- if .Version.IsUrgent}}
+- 紧急升级：{{md .Version.UrgentReason}}
+{{- end}}
+- 版本负责人：{{md .Version.VersionOwner}}
+- 厂家负责人：{{md .Version.VendorOwner}}
+- 条目数：{{.ItemCount}}
+- 开发人员：{{md (join .Developers "、")}}
+- 测试人员：{{md (join .Testers "、")}}
+- 生成时间：{{.GeneratedAt}}
+
+## 上线注意事项
+{{if not (or .Scripts .Caches .Restarts)}}
+无需执行脚本、刷新缓存或重启。
+{{end}}
+{{- with .Scripts}}
+### 需要执行脚本
+{{range .}}
+- {{md .ID}} {{md .Name}}（开发：{{md .Developer}}）
+{{- end}}
+{{end}}
+{{- with .Caches}}
+### 涉及缓存
+{{range .}}
+- {{md .ID}} {{md .Name}}（开发：{{md .Developer}}）
+{{- end}}
+{{end}}
+{{- with .Restarts}}
+### 需要重启
+{{range .}}
+- {{md .ID}} {{md .Name}}（开发：{{md .Developer}}）
+{{- end}}
+{{end}}
+## 测试结果
+{{if .Stages}}
+| 阶段 | 结果 | 测试人员 | 执行次数 | 完成时间 |
+| --- | --- | --- | --- | --- |
+{{- range .Stages}}
+| {{md .Name}} | {{md .Outcome}} | {{md .Operator}} | {{.Runs}} | {{md .CompletedAt}} |
+{{- end}}
+{{else}}
+没有执行测试阶段。
+{{end}}
+## 条目
+{{range .Groups}}
+### {{md .Type}}（{{len .Items}}）
+
+| 条目ID | 名称 | 需求ID | 开发人员 | 测试人员 | 注意事项 |{{range $.Stages}} {{md .Name}} |{{end}}
+| --- | --- | --- | --- | --- | --- |{{range $.Stages}} --- |{{end}}
+{{- range .Items}}
+| {{md .ID}} | {{md .Name}} | {{md .RequirementID}} | {{md .Developer}} | {{md .Tester}} | {{md .Notes}} |{{range .Results}} {{md .}} |{{end}}
+{{- end}}
+{{else}}
+版本中没有条目。
+{{end}}
+{{- with .Suspensions}}
+## 挂起条目
+
+| 条目ID | 名称 | 挂起阶段 | 原因 |
+| --- | --- | --- | --- |
+{{- range .}}
+| {{md .ItemID}} | {{md .ItemName}} | {{md .StageName}} | {{md .Reason}} |
+{{- end}}
+{{end}}`))
+
+var releaseNoteHTML = htmltemplate.Must(htmltemplate.New("html").Funcs(releaseNoteFuncs).Parse(
+	`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Version.Name}} 发布说明</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 32px; color: #333; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: left; font-size: 14px; }
+th { background: #f5f5f5; }
+.attention { color: #c0392b; }
+</style>
+</head>
+<body>
+<h1>{{.Version.Name}} 发布说明</h1>
+<ul>
+<li>版本ID：{{.Version.ID}}</li>
+{{- if .Version.IsUrgent}}
+<li class="attention">紧急升级：{{text .Version.UrgentReason}}</li>
+{{- end}}
+<li>版本负责人：{{text .Version.VersionOwner}}</li>
+<li>厂家负责人：{{text .Version.VendorOwner}}</li>
+<li>条目数：{{.ItemCount}}</li>
+<li>开发人员：{{text (join .Developers "、")}}</li>
+<li>测试人员：{{text (join .Testers "、")}}</li>
+<li>生成时间：{{.GeneratedAt}}</li>
+</ul>
+
+<h2>上线注意事项</h2>
+{{- if not (or .Scripts .Caches .Restarts)}}
+<p>无需执行脚本、刷新缓存或重启。</p>
+{{- end}}
+{{- with .Scripts}}
+<h3 class="attention">需要执行脚本</h3>
+<ul>
+{{- range .}}
+<li>{{.ID}} {{.Name}}（开发：{{text .Developer}}）</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- with .Caches}}
+<h3 class="attention">涉及缓存</h3>
+<ul>
+{{- range .}}
+<li>{{.ID}} {{.Name}}（开发：{{text .Developer}}）</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- with .Restarts}}
+<h3 class="attention">需要重启</h3>
+<ul>
+{{- range .}}
+<li>{{.ID}} {{.Name}}（开发：{{text .Developer}}）</li>
+{{- end}}
+</ul>
+{{- end}}
+
+<h2>测试结果</h2>
+{{- if .Stages}}
+<table>
+<tr><th>阶段</th><th>结果</th><th>测试人员</th><th>执行次数</th><th>完成时间</th></tr>
+{{- range .Stages}}
+<tr><td>{{.Name}}</td><td>{{text .Outcome}}</td><td>{{text .Operator}}</td><td>{{.Runs}}</td><td>{{text .CompletedAt}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>没有执行测试阶段。</p>
+{{- end}}
+
+<h2>条目</h2>
+{{- range .Groups}}
+<h3>{{text .Type}}（{{len .Items}}）</h3>
+<table>
+<tr><th>条目ID</th><th>名称</th><th>需求ID</th><th>开发人员</th><th>测试人员</th><th>注意事项</th>{{range $.Stages}}<th>{{.Name}}</th>{{end}}</tr>
+{{- range .Items}}
+<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{text .RequirementID}}</td><td>{{text .Developer}}</td><td>{{text .Tester}}</td><td class="attention">{{.Notes}}</td>{{range .Results}}<td>{{text .}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>版本中没有条目。</p>
+{{- end}}
+{{- with .Suspensions}}
+
+<h2>挂起条目</h2>
+<table>
+<tr><th>条目ID</th><th>名称</th><th>挂起阶段</th><th>原因</th></tr>
+{{- range .}}
+<tr><td>{{.ItemID}}</td><td>{{text .ItemName}}</td><td>{{text .StageName}}</td><td>{{text .Reason}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// renderReleaseNote 渲染 Markdown 和 HTML 发布说明
+func renderReleaseNote(data ReleaseNoteData) (*ReleaseNoteModel, error) {
+	var markdown, html strings.Builder
+	if err := releaseNoteMarkdown.Execute(&markdown, data); err != nil {
+		return nil, err
+	}
+	if err := releaseNoteHTML.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return &ReleaseNoteModel{VersionID: data.Version.ID, Markdown: markdown.String(), HTML: html.String()}, nil
+}
+
+// ============================================================
+// 下载
+// ============================================================
+
+// getReleaseNoteHandler 以附件返回版本发布说明，format 为 md（默认）或 html
+func getReleaseNoteHandler(c *gin.Context) {
+	versionID := c.Param("versionId")
+	note, err := store.GetReleaseNote(versionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "发布说明不存在，版本完成后生成"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", ReleaseNoteMarkdown)
+	var content, contentType string
+	switch format {
+	case ReleaseNoteMarkdown:
+		content, contentType = note.Markdown, "text/markdown; charset=utf-8"
+	case ReleaseNoteHTML:
+		content, contentType = note.HTML, "text/html; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的格式 " + format + "，可选 md/html"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-release-notes.%s"`, versionID, format))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, contentType, []byte(content))
+}
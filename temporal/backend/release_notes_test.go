@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReleaseNoteEscapesContent(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	item := ItemModel{ID: "I1", Name: "修复 | <script>alert(1)</script>", Type: "BUG", Status: ItemStatusAuditComplete, HasScript: true}
+	if err := s.CreateItem(&item); err != nil {
+		t.Fatal(err)
+	}
+	version := VersionModel{ID: "V1", Name: "v1 <b>", Status: "running", ItemIDs: `["I1"]`}
+	if _, err := s.CreateVersionWithItems(&version, []string{"I1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := loadReleaseNoteData("V1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Groups) != 1 || data.Groups[0].Type != "BUG" || len(data.Scripts) != 1 {
+		t.Fatalf("条目分组不正确: %+v", data)
+	}
+	note, err := renderReleaseNote(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(note.HTML, "<script>") || strings.Contains(note.HTML, "<b>") {
+		t.Fatalf("HTML 未转义条目内容:\n%s", note.HTML)
+	}
+	if !strings.Contains(note.Markdown, `修复 \| \<script\>`) {
+		t.Fatalf("Markdown 未转义表格分隔符:\n%s", note.Markdown)
+	}
+}
+
+// TestReleaseNoteInvalidItemIDs 条目列表损坏时返回错误，不生成空的发布说明
+func TestReleaseNoteInvalidItemIDs(t *testing.T) {
+	s := newMigratedStore(t)
+	store = s
+	version := VersionModel{ID: "V1", Name: "v1", Status: "running", ItemIDs: `["I1"`}
+	if _, err := s.CreateVersionWithItems(&version, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadReleaseNoteData("V1", time.Now()); err == nil {
+		t.Fatal("条目列表无效时应返回错误")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============================================================
+// 编号分配
+// 条目和版本编号由序列表分配，每个编号前缀一个计数器，在创建记录的事务中递增：
+//   并发创建时计数器行被锁定，不会分配到相同的编号
+//   删除记录不会回收编号；事务回滚时计数器一起回滚
+// 编号格式可以配置：{date:布局} 为 Go 时间布局的日期，{seq:位数} 为补零的序号，
+// 如 ITEM-{date:20060102}-{seq:4}；日期变化后前缀不同，序号重新从 1 开始
+// ============================================================
+
+// 序列名称
+const (
+	SequenceItem    = "item"
+	SequenceVersion = "version"
+)
+
+// idFormats 各序列的编号格式
+var idFormats = map[string]string{
+	SequenceItem:    "ITEM-{date:20060102}-{seq:4}",
+	SequenceVersion: "V{date:200601}-{seq:3}",
+}
+
+// sequenceModels 各序列编号所属的表，首次使用某个前缀时从已有编号中取最大序号
+var sequenceModels = map[string]interface{}{
+	SequenceItem:    &ItemModel{},
+	SequenceVersion: &VersionModel{},
+}
+
+// SequenceModel 编号计数器，Name 为序列名称和编号前缀
+type SequenceModel struct {
+	Name      string    `gorm:"primaryKey;size:150" json:"name"`
+	Value     int64     `json:"value"` // 最近分配的序号
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (SequenceModel) TableName() string { return "upgrade_sequences" }
+
+var idPlaceholder = regexp.MustCompile(`\{(date|seq):([^}]*)\}`)
+
+// idFormat 解析后的编号格式
+type idFormat struct {
+	pattern string
+	width   int // 序号位数，超过时按实际位数
+}
+
+// parseIDFormat 解析编号格式，格式中需要且只能有一个 {seq:位数}
+func parseIDFormat(pattern string) (*idFormat, error) {
+	f := &idFormat{pattern: pattern}
+	seqs := 0
+	for _, m := range idPlaceholder.FindAllStringSubmatch(pattern, -1) {
+		switch m[1] {
+		case "seq":
+			seqs++
+			width, err := strconv.Atoi(m[2])
+			if err != nil || width < 1 || width > 12 {
+				return nil, fmt.Errorf("编号格式 %s 的序号位数 %s 无效，应为 1-12", pattern, m[2])
+			}
+			f.width = width
+		case "date":
+			if m[2] == "" {
+				return nil, fmt.Errorf("编号格式 %s 的日期布局不能为空", pattern)
+			}
+		}
+	}
+	if seqs != 1 {
+		return nil, fmt.Errorf("编号格式 %s 需要且只能有一个 {seq:位数}", pattern)
+	}
+	return f, nil
+}
+
+// render 按时间生成序号前后的部分
+func (f *idFormat) render(now time.Time) (string, string) {
+	var prefix, suffix strings.Builder
+	out := &prefix
+	last := 0
+	for _, loc := range idPlaceholder.FindAllStringSubmatchIndex(f.pattern, -1) {
+		out.WriteString(f.pattern[last:loc[0]])
+		kind, arg := f.pattern[loc[2]:loc[3]], f.pattern[loc[4]:loc[5]]
+		if kind == "seq" {
+			out = &suffix
+		} else {
+			out.WriteString(now.Format(arg))
+		}
+		last = loc[1]
+	}
+	out.WriteString(f.pattern[last:])
+	return prefix.String(), suffix.String()
+}
+
+// initIDFormats 校验并设置编号格式，未配置的序列使用默认格式
+func initIDFormats(formats map[string]string) error {
+	for name, pattern := range formats {
+		if _, ok := idFormats[name]; !ok {
+			return fmt.Errorf("未知的编号序列 %s", name)
+		}
+		if _, err := parseIDFormat(pattern); err != nil {
+			return err
+		}
+	}
+	for name, pattern := range formats {
+		idFormats[name] = pattern
+	}
+	return nil
+}
+
+// NextID 在事务中分配下一个编号，需要与创建记录使用同一个事务
+func NextID(tx *gorm.DB, name string, now time.Time) (string, error) {
+	format, err := parseIDFormat(idFormats[name])
+	if err != nil {
+		return "", err
+	}
+	prefix, suffix := format.render(now)
+	seq, err := nextSequence(tx, name+":"+prefix+"#"+suffix, func() (int64, error) {
+		return maxExistingSequence(tx, name, prefix, suffix)
+	})
+	if err != nil {
+		return "", fmt.Errorf("分配编号失败: %w", err)
+	}
+	return fmt.Sprintf("%s%0*d%s", prefix, format.width, seq, suffix), nil
+}
+
+// nextSequence 递增计数器并返回新值，计数器不存在时以 seed 的结果创建
+func nextSequence(tx *gorm.DB, key string, seed func() (int64, error)) (int64, error) {
+	var counter SequenceModel
+	err := tx.Where("name = ?", key).Take(&counter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		start, err := seed()
+		if err != nil {
+			return 0, err
+		}
+		// 并发创建同一个计数器时只有一个成功，其余的使用已创建的计数器
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&SequenceModel{Name: key, Value: start}).Error; err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	// 递增会锁定计数器行直到事务结束，并发的分配依次进行
+	if err := tx.Model(&SequenceModel{}).Where("name = ?", key).
+		Update("value", gorm.Expr("value + 1")).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", key).Take(&counter).Error; err != nil {
+		return 0, err
+	}
+	return counter.Value, nil
+}
+
+// maxExistingSequence 序列功能之前按相同前缀生成的编号中最大的序号，避免分配到已有编号
+func maxExistingSequence(tx *gorm.DB, name, prefix, suffix string) (int64, error) {
+	model, ok := sequenceModels[name]
+	if !ok {
+		return 0, nil
+	}
+	var ids []string
+	if err := tx.Model(model).Where("id LIKE ? ESCAPE '!'", escapeLike(prefix)+"%").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	var max int64
+	for _, id := range ids {
+		if !strings.HasSuffix(id, suffix) || len(id) < len(prefix)+len(suffix) {
+			continue
+		}
+		digits := id[len(prefix) : len(id)-len(suffix)]
+		if digits == "" || strings.Trim(digits, "0123456789") != "" {
+			continue
+		}
+		if n, err := strconv.ParseInt(digits, 10, 64); err == nil && n > max {
+			max = n
+		}
+	}
+	return max, nil
+}
+
+// escapeLike 转义 LIKE 的通配符，转义字符为 !
+// 各数据库默认的转义字符不同（MySQL、PostgreSQL 为反斜杠，SQLite 没有），查询中需要声明 ESCAPE '!'
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 流程模拟
+// 在 Temporal 测试环境中运行 UpgradeWorkflow，按脚本在指定时间发送审批、测试结果和暂停/恢复/取消；
+// 脚本之间的空档由测试环境跳过，没有及时处理的阶段按配置超时、提醒或升级。
+// 所有 Activity 都被替换为内存实现，不会写数据库或发送通知
+// 模拟在 API 进程中运行：需要认证用户，同时运行的模拟数和阶段、脚本、条目数有上限
+// ============================================================
+
+// 模拟脚本动作
+const (
+	SimulateApprove = "approve" // 审批通过
+	SimulateReject  = "reject"  // 审批驳回
+	SimulateTest    = "test"    // 提交条目测试结果
+	SimulatePause   = "pause"   // 暂停版本
+	SimulateResume  = "resume"  // 恢复版本
+	SimulateCancel  = "cancel"  // 取消版本
+)
+
+// SimulationStep 模拟脚本中的一步
+type SimulationStep struct {
+	At       string `json:"at"`       // 相对流程开始的时间，如 "30m"、"2h"
+	Action   string `json:"action"`   // approve/reject/test/pause/resume/cancel
+	Stage    string `json:"stage"`    // 阶段标识，暂停/恢复/取消不需要
+	Operator string `json:"operator"` // 审批人或测试人员
+	ItemID   string `json:"item_id"`  // 测试条目，仅 test
+	Passed   bool   `json:"passed"`   // 测试是否通过，仅 test
+	Comment  string `json:"comment"`  // 审批备注、BUG 描述或暂停/取消原因
+}
+
+// SimulateRequest 模拟请求，使用已保存的流程配置（可指定修订）或请求中的阶段配置
+type SimulateRequest struct {
+	FlowConfigID uint             `json:"flow_config_id"`
+	Revision     int              `json:"revision"`
+	Stages       []StageConfig    `json:"stages"`
+	Version      UpgradeVersion   `json:"version"`
+	Items        []UpgradeItem    `json:"items"`
+	Steps        []SimulationStep `json:"steps"`
+}
+
+// SimulateResult 模拟结果
+type SimulateResult struct {
+	Status        string                       `json:"status"`
+	Message       string                       `json:"message"`
+	Error         string                       `json:"error,omitempty"`
+	Duration      string                       `json:"duration"` // 流程耗时（工作流时间）
+	Timeline      []StageTimeline              `json:"timeline"`
+	Approvals     []ApprovalAction             `json:"approvals"`
+	TestResults   []TestSubmission             `json:"test_results"`
+	ItemResults   map[string]map[string]string `json:"item_results"`
+	ItemStatus    map[string]string            `json:"item_status"` // 条目最终状态
+	Transitions   []StageTransition            `json:"transitions"`
+	Audits        []AuditEvent                 `json:"audits"`
+	Notifications []Notification               `json:"notifications"`
+}
+
+// simulationTimeout 单次模拟的最长实际运行时间
+const simulationTimeout = 30 * time.Second
+
+// 模拟的规模上限
+const (
+	maxConcurrentSimulations = 2   // 同时运行的模拟数，超过时返回 429
+	maxSimulationStages      = 30  // 阶段数
+	maxSimulationSteps       = 200 // 脚本步骤数
+	maxSimulationItems       = 200 // 条目数
+)
+
+// simulationSlots 正在运行的模拟
+var simulationSlots = make(chan struct{}, maxConcurrentSimulations)
+
+// checkSimulationSize 校验模拟规模，阶段配置可能来自请求或已保存的配置
+func checkSimulationSize(stages int, req SimulateRequest) error {
+	switch {
+	case stages > maxSimulationStages:
+		return fmt.Errorf("阶段数 %d 超过上限 %d", stages, maxSimulationStages)
+	case len(req.Steps) > maxSimulationSteps:
+		return fmt.Errorf("脚本步骤数 %d 超过上限 %d", len(req.Steps), maxSimulationSteps)
+	case len(req.Items) > maxSimulationItems:
+		return fmt.Errorf("条目数 %d 超过上限 %d", len(req.Items), maxSimulationItems)
+	}
+	return nil
+}
+
+// simulationRecorder 记录模拟中 Activity 的调用，Activity 可能在不同 goroutine 中执行
+type simulationRecorder struct {
+	mu            sync.Mutex
+	transitions   []StageTransition
+	audits        []AuditEvent
+	notifications []Notification
+	itemStatus    map[string]string // 条目ID -> 状态，按条目生命周期变更
+}
+
+func (r *simulationRecorder) transition(_ context.Context, t StageTransition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions = append(r.transitions, t)
+	return nil
+}
+
+func (r *simulationRecorder) audit(_ context.Context, event AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audits = append(r.audits, event)
+	return nil
+}
+
+func (r *simulationRecorder) transitionItems(_ context.Context, req ItemTransitionRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, itemID := range req.ItemIDs {
+		if err := checkItemTransition(itemID, r.itemStatus[itemID], req.To); err != nil {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "IllegalTransition", err)
+		}
+	}
+	for _, itemID := range req.ItemIDs {
+		r.itemStatus[itemID] = req.To
+	}
+	return nil
+}
+
+func (r *simulationRecorder) suspendItems(_ context.Context, req SuspendItemsRequest) error {
+	itemIDs := make([]string, 0, len(req.Reasons))
+	for itemID := range req.Reasons {
+		itemIDs = append(itemIDs, itemID)
+	}
+	return r.transitionItems(context.Background(), ItemTransitionRequest{ItemIDs: itemIDs, To: ItemStatusSuspended})
+}
+
+func (r *simulationRecorder) restoreItems(_ context.Context, req RestoreItemsRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range req.Items {
+		if checkItemTransition(item.ID, r.itemStatus[item.ID], item.Status) == nil {
+			r.itemStatus[item.ID] = item.Status
+		}
+	}
+	return nil
+}
+
+func (r *simulationRecorder) notify(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+// scheduledStep 解析后的脚本步骤
+type scheduledStep struct {
+	at   time.Duration
+	step SimulationStep
+}
+
+// parseSimulationSteps 校验脚本并按时间排序，同一时间的步骤保持脚本顺序
+func parseSimulationSteps(steps []SimulationStep, stages []StageConfig) ([]scheduledStep, error) {
+	v := &flowValidator{}
+	stageTypes := make(map[string]string, len(stages))
+	for _, stage := range stages {
+		stageTypes[stage.Key] = stage.Type
+	}
+
+	scheduled := make([]scheduledStep, 0, len(steps))
+	for i, step := range steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		at, err := time.ParseDuration(step.At)
+		if err != nil || at < 0 {
+			v.add(field+".at", "时间 %q 无效，应为 30m、2h 等非负时长", step.At)
+		}
+		scheduled = append(scheduled, scheduledStep{at: at, step: step})
+		if step.Action == SimulatePause || step.Action == SimulateResume || step.Action == SimulateCancel {
+			continue
+		}
+
+		stageType, ok := stageTypes[step.Stage]
+		if !ok {
+			v.add(field+".stage", "阶段 %s 不存在", step.Stage)
+		}
+		switch step.Action {
+		case SimulateApprove, SimulateReject:
+			if ok && stageType == "test" {
+				v.add(field+".action", "测试阶段只能提交测试结果")
+			}
+		case SimulateTest:
+			if ok && stageType != "test" {
+				v.add(field+".action", "阶段 %s 不是测试阶段", step.Stage)
+			}
+			if step.ItemID == "" {
+				v.add(field+".item_id", "条目ID不能为空")
+			}
+		default:
+			v.add(field+".action", "动作 %s 无效，应为 approve/reject/test/pause/resume/cancel", step.Action)
+		}
+	}
+	if err := v.result(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(scheduled, func(i, j int) bool { return scheduled[i].at < scheduled[j].at })
+	return scheduled, nil
+}
+
+// SimulateFlow 运行一次流程模拟，stages 需要已通过校验；未设置状态的条目按审核完成处理
+func SimulateFlow(stages []StageConfig, version UpgradeVersion, items []UpgradeItem, steps []scheduledStep) (*SimulateResult, error) {
+	var suite testsuite.WorkflowTestSuite
+	// 测试环境默认把调试日志打到标准输出，模拟结果已包含所需信息
+	suite.SetLogger(log.NewStructuredLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetTestTimeout(simulationTimeout)
+	startedAt := env.Now()
+
+	env.RegisterWorkflow(UpgradeWorkflow)
+	registerSimulationActivities(env, stages)
+
+	recorder := &simulationRecorder{itemStatus: make(map[string]string, len(items))}
+	for i := range items {
+		if items[i].Status == "" {
+			items[i].Status = ItemStatusAuditComplete
+		}
+		recorder.itemStatus[items[i].ID] = items[i].Status
+	}
+	env.OnActivity(StartStageActivity, mock.Anything, mock.Anything).Return(recorder.transition)
+	env.OnActivity(FinishStageActivity, mock.Anything, mock.Anything).Return(recorder.transition)
+	env.OnActivity(RecordAuditActivity, mock.Anything, mock.Anything).Return(recorder.audit)
+	env.OnActivity(NotifyActivity, mock.Anything, mock.Anything).Return(recorder.notify)
+	env.OnActivity(TransitionItemsActivity, mock.Anything, mock.Anything).Return(recorder.transitionItems)
+	env.OnActivity(SuspendItemsActivity, mock.Anything, mock.Anything).Return(recorder.suspendItems)
+	env.OnActivity(RestoreItemsActivity, mock.Anything, mock.Anything).Return(recorder.restoreItems)
+
+	for _, s := range steps {
+		step := s.step
+		env.RegisterDelayedCallback(func() {
+			timestamp := env.Now().Format(time.RFC3339)
+			control := VersionControl{Operator: step.Operator, Reason: step.Comment, Timestamp: timestamp}
+			switch step.Action {
+			case SimulatePause:
+				env.SignalWorkflow(SignalPause, control)
+				return
+			case SimulateResume:
+				env.SignalWorkflow(SignalResume, control)
+				return
+			case SimulateCancel:
+				env.SignalWorkflow(SignalCancel, control)
+				return
+			}
+			if step.Action == SimulateTest {
+				env.SignalWorkflow(step.Stage+"-test-result", TestSubmission{
+					ItemID:      step.ItemID,
+					Stage:       step.Stage,
+					Tester:      step.Operator,
+					Passed:      step.Passed,
+					BugDesc:     step.Comment,
+					SubmittedAt: timestamp,
+				})
+				return
+			}
+			env.SignalWorkflow(step.Stage+"-approval", ApprovalAction{
+				Stage:     step.Stage,
+				Operator:  step.Operator,
+				Approved:  step.Action == SimulateApprove,
+				Comment:   step.Comment,
+				Timestamp: timestamp,
+			})
+		}, s.at)
+	}
+
+	env.ExecuteWorkflow(UpgradeWorkflow, UpgradeWorkflowRequest{
+		Version: version,
+		Items:   items,
+	})
+	if !env.IsWorkflowCompleted() {
+		return nil, fmt.Errorf("模拟未在 %s 内结束", simulationTimeout)
+	}
+
+	result := &SimulateResult{Duration: env.Now().Sub(startedAt).String()}
+	var workflowResult UpgradeWorkflowResult
+	if err := env.GetWorkflowResult(&workflowResult); err != nil {
+		// 流程以错误结束时没有返回值
+		workflowResult.Status = "failed"
+		workflowResult.Message = err.Error()
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) {
+			workflowResult.Message = appErr.Message()
+		}
+		result.Error = err.Error()
+	}
+	result.Status = workflowResult.Status
+	result.Message = workflowResult.Message
+
+	if resp, err := env.QueryWorkflow(QueryWorkflowState); err == nil {
+		var state WorkflowState
+		if err := resp.Get(&state); err == nil {
+			result.Timeline = state.Timeline
+			result.Approvals = state.Approvals
+			result.TestResults = state.TestResults
+			result.ItemResults = state.ItemResults
+		}
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	result.Transitions = recorder.transitions
+	result.Audits = recorder.audits
+	result.Notifications = recorder.notifications
+	result.ItemStatus = recorder.itemStatus
+	return result, nil
+}
+
+// registerSimulationActivities 注册流程用到的 Activity，读取配置返回模拟的阶段，其余写操作为空操作
+func registerSimulationActivities(env *testsuite.TestWorkflowEnvironment, stages []StageConfig) {
+	env.RegisterActivity(GetFlowConfigActivity)
+	env.RegisterActivity(GetFlowRevisionActivity)
+	env.RegisterActivity(RecordTestResultActivity)
+	env.RegisterActivity(SuspendItemsActivity)
+	env.RegisterActivity(StartStageActivity)
+	env.RegisterActivity(FinishStageActivity)
+	env.RegisterActivity(FinishVersionActivity)
+	env.RegisterActivity(RecordAuditActivity)
+	env.RegisterActivity(NotifyActivity)
+	env.RegisterActivity(EscalateStageActivity)
+	env.RegisterActivity(UpdateVersionStatusActivity)
+	env.RegisterActivity(RestoreItemsActivity)
+	env.RegisterActivity(TransitionItemsActivity)
+	env.RegisterActivity(ArchiveKnowledgeActivity)
+
+	env.OnActivity(GetFlowConfigActivity, mock.Anything, mock.Anything).Return(stages, nil)
+	env.OnActivity(GetFlowRevisionActivity, mock.Anything, mock.Anything).Return(stages, nil)
+	env.OnActivity(RecordTestResultActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(FinishVersionActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(EscalateStageActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(UpdateVersionStatusActivity, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(ArchiveKnowledgeActivity, mock.Anything, mock.Anything).Return(nil)
+}
+
+// ============================================================
+// HTTP 处理
+// ============================================================
+
+func simulateFlowHandler(c *gin.Context) {
+	operator, ok := requireOperator(c)
+	if !ok {
+		return
+	}
+	var req SimulateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkSimulationSize(len(req.Stages), req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stages := req.Stages
+	if req.FlowConfigID > 0 {
+		var err error
+		if req.Revision > 0 {
+			var revision *FlowRevision
+			if revision, err = store.GetFlowRevision(req.FlowConfigID, req.Revision); err == nil {
+				stages, err = GetRevisionStages(revision)
+			}
+		} else {
+			var config *FlowConfig
+			if config, err = store.GetFlowConfig(req.FlowConfigID); err == nil {
+				stages, err = GetFlowStages(config)
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "配置不存在"})
+			return
+		}
+		if err := checkSimulationSize(len(stages), req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := ValidateFlowStages(stages); err != nil {
+		c.JSON(http.StatusBadRequest, validationResponse(err))
+		return
+	}
+	// 紧急版本的脚本可以操作理由确认阶段，工作流中同样会加上该阶段
+	executed := stages
+	if req.Version.IsUrgent {
+		executed = urgentStages(stages)
+	}
+	steps, err := parseSimulationSteps(req.Steps, executed)
+	if err != nil {
+		resp := gin.H{"error": "模拟脚本无效: " + err.Error()}
+		if errs, ok := err.(ValidationErrors); ok {
+			resp["fields"] = errs
+		}
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	version := req.Version
+	if version.ID == "" {
+		version.ID = "SIMULATION"
+	}
+	if version.Name == "" {
+		version.Name = "模拟版本"
+	}
+
+	select {
+	case simulationSlots <- struct{}{}:
+		defer func() { <-simulationSlots }()
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "正在运行的模拟较多，请稍后再试"})
+		return
+	}
+	result, err := SimulateFlow(stages, version, req.Items, steps)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Info("流程模拟完成",
+		zap.String("operator", operator),
+		zap.Uint("flowConfigId", req.FlowConfigID),
+		zap.Int("steps", len(steps)),
+		zap.String("status", result.Status),
+		zap.String("duration", result.Duration))
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// TestSimulateFlowHandlerLimits 模拟需要认证用户，超过规模或并发上限时拒绝
+func TestSimulateFlowHandlerLimits(t *testing.T) {
+	logger = zap.NewNop()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware(AuthConfig{}))
+	r.POST("/api/flow-configs/simulate", simulateFlowHandler)
+	simulate := func(user string, stages []StageConfig) int {
+		body, _ := json.Marshal(SimulateRequest{Stages: stages})
+		req := httptest.NewRequest(http.MethodPost, "/api/flow-configs/simulate", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if user != "" {
+			req.Header.Set(headerAuthUser, user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	stage := approvalStage("a")
+	stage.Order = 1
+	stages := []StageConfig{stage}
+
+	if code := simulate("", stages); code != http.StatusUnauthorized {
+		t.Fatalf("未认证时返回 %d，期望 401", code)
+	}
+	oversized := make([]StageConfig, maxSimulationStages+1)
+	if code := simulate("alice", oversized); code != http.StatusBadRequest {
+		t.Fatalf("阶段数超过上限时返回 %d，期望 400", code)
+	}
+
+	for i := 0; i < maxConcurrentSimulations; i++ {
+		simulationSlots <- struct{}{}
+	}
+	code := simulate("alice", stages)
+	for i := 0; i < maxConcurrentSimulations; i++ {
+		<-simulationSlots
+	}
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("并发模拟已满时返回 %d，期望 429", code)
+	}
+	if code := simulate("alice", stages); code != http.StatusOK {
+		t.Fatalf("模拟返回 %d，期望 200", code)
+	}
+}
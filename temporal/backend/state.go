@@ -0,0 +1,283 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// ============================================================
+// 工作流实时状态
+// 由 UpgradeWorkflow 维护，Query 只读取不修改
+// ============================================================
+
+func newWorkflowState(versionID string, stages []StageConfig) *WorkflowState {
+	state := &WorkflowState{
+		VersionID:   versionID,
+		Status:      "running",
+		ItemResults: make(map[string]map[string]string),
+		Iterations:  make(map[string]int),
+		stages:      stages,
+	}
+	for _, stage := range stages {
+		if stage.Enabled {
+			state.waiting = append(state.waiting, stage.Key)
+		}
+	}
+	state.rebuildTimeline()
+	return state
+}
+
+// registerQueries 注册 Query 处理函数
+func registerQueries(ctx workflow.Context, state *WorkflowState) error {
+	if err := workflow.SetQueryHandler(ctx, QueryWorkflowState, func() (WorkflowState, error) {
+		return *state, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetQueryHandler(ctx, QueryCurrentStage, func() (string, error) {
+		return state.CurrentStage, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetQueryHandler(ctx, QueryActiveStages, func() ([]ActiveStage, error) {
+		return state.ActiveStages, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetQueryHandler(ctx, QueryStageStartedAt, func() (string, error) {
+		return state.StageStartedAt, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetQueryHandler(ctx, QueryPendingApprovers, func() ([]string, error) {
+		return state.PendingApprovers, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetQueryHandler(ctx, QueryApprovalHistory, func() ([]ApprovalAction, error) {
+		return state.Approvals, nil
+	}); err != nil {
+		return err
+	}
+	return workflow.SetQueryHandler(ctx, QueryItemResults, func() (map[string]map[string]string, error) {
+		return state.ItemResults, nil
+	})
+}
+
+// enterStage 进入阶段
+func (s *WorkflowState) enterStage(ctx workflow.Context, stage StageConfig, approvers []string) {
+	now := workflow.Now(ctx).Format(time.RFC3339)
+	s.Iterations[stage.Key]++
+	s.waiting = removeKey(s.waiting, stage.Key)
+
+	active := ActiveStage{
+		Key:              stage.Key,
+		Name:             stage.Name,
+		StartedAt:        now,
+		PendingApprovers: approvers,
+	}
+	if stage.Type != "test" {
+		active.ApprovalMode = stage.ApprovalMode
+		if active.ApprovalMode == "" {
+			active.ApprovalMode = ApprovalModeAny
+		}
+		active.RequiredApprovals = requiredApprovals(stage, len(approvers))
+	}
+	s.ActiveStages = append(s.ActiveStages, active)
+	s.history = append(s.history, StageTimeline{
+		Stage:     stage.Name,
+		Key:       stage.Key,
+		Status:    "in_progress",
+		Iteration: s.Iterations[stage.Key],
+		StartedAt: now,
+	})
+	s.syncActive()
+}
+
+// finishStage 结束进行中的阶段，status 为 completed/failed/returned/cancelled
+func (s *WorkflowState) finishStage(ctx workflow.Context, stageKey, status, operator string) {
+	if entry := s.activeEntry(stageKey); entry != nil {
+		entry.Status = status
+		entry.CompletedAt = workflow.Now(ctx).Format(time.RFC3339)
+		entry.Operator = operator
+	}
+
+	for i, active := range s.ActiveStages {
+		if active.Key == stageKey {
+			s.ActiveStages = append(s.ActiveStages[:i:i], s.ActiveStages[i+1:]...)
+			break
+		}
+	}
+	s.syncActive()
+}
+
+// resetStages 退回后重新等待执行的阶段
+func (s *WorkflowState) resetStages(keys []string) {
+	for _, key := range keys {
+		if !containsKey(s.waiting, key) {
+			s.waiting = append(s.waiting, key)
+		}
+	}
+	s.rebuildTimeline()
+}
+
+// recordApproval 记录审批/驳回
+func (s *WorkflowState) recordApproval(action ApprovalAction) {
+	s.Approvals = append(s.Approvals, action)
+}
+
+// recordTestResult 记录条目测试结果
+func (s *WorkflowState) recordTestResult(submission TestSubmission, testResult string) {
+	s.TestResults = append(s.TestResults, submission)
+	if s.ItemResults[submission.ItemID] == nil {
+		s.ItemResults[submission.ItemID] = make(map[string]string)
+	}
+	s.ItemResults[submission.ItemID][submission.Stage] = testResult
+}
+
+// recordAudit 将审计事件挂到对应阶段最近一次执行的时间线上
+func (s *WorkflowState) recordAudit(event AuditEvent) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Key == event.Stage {
+			s.history[i].Audits = append(s.history[i].Audits, event)
+			break
+		}
+	}
+	s.rebuildTimeline()
+}
+
+// updateCountersign 更新审批阶段的会签进度
+func (s *WorkflowState) updateCountersign(stageKey string, cs *countersign) {
+	for i := range s.ActiveStages {
+		if s.ActiveStages[i].Key == stageKey {
+			s.ActiveStages[i].PendingApprovers = cs.pending()
+			s.ActiveStages[i].Signatures = cs.signatures()
+		}
+	}
+	s.syncActive()
+}
+
+// setDeadline 更新审批阶段的截止时间和升级对象
+func (s *WorkflowState) setDeadline(stageKey string, deadline time.Time, escalatedTo string) {
+	for i := range s.ActiveStages {
+		if s.ActiveStages[i].Key == stageKey {
+			s.ActiveStages[i].Deadline = deadline.Format(time.RFC3339)
+			s.ActiveStages[i].EscalatedTo = escalatedTo
+		}
+	}
+}
+
+// pause 暂停版本
+func (s *WorkflowState) pause(ctx workflow.Context, signal VersionControl) {
+	s.Status = "paused"
+	s.Pause = &PauseInfo{
+		PausedBy: signal.Operator,
+		Reason:   signal.Reason,
+		PausedAt: workflow.Now(ctx).Format(time.RFC3339),
+	}
+}
+
+// resume 恢复版本，进行中阶段的截止时间顺延暂停的时长；返回暂停时长
+func (s *WorkflowState) resume(ctx workflow.Context) time.Duration {
+	var paused time.Duration
+	if pausedAt, err := time.Parse(time.RFC3339, s.Pause.PausedAt); err == nil {
+		paused = workflow.Now(ctx).Sub(pausedAt)
+	}
+	for i := range s.ActiveStages {
+		if deadline, err := time.Parse(time.RFC3339, s.ActiveStages[i].Deadline); err == nil {
+			s.ActiveStages[i].Deadline = deadline.Add(paused).Format(time.RFC3339)
+		}
+	}
+	s.Status = "running"
+	s.Pause = nil
+	return paused
+}
+
+// paused 版本是否暂停中
+func (s *WorkflowState) paused() bool {
+	return s.Pause != nil
+}
+
+// setPendingItems 更新测试阶段的待测试条目
+func (s *WorkflowState) setPendingItems(stageKey string, pending map[string]bool) {
+	items := make([]string, 0, len(pending))
+	for itemID := range pending {
+		items = append(items, itemID)
+	}
+	sort.Strings(items)
+
+	for i := range s.ActiveStages {
+		if s.ActiveStages[i].Key == stageKey {
+			s.ActiveStages[i].PendingItems = items
+		}
+	}
+	s.syncActive()
+}
+
+// syncActive 根据进行中的阶段更新汇总字段和时间线
+func (s *WorkflowState) syncActive() {
+	s.CurrentStage = ""
+	s.StageStartedAt = ""
+	s.PendingApprovers = nil
+	s.PendingItems = nil
+	if len(s.ActiveStages) > 0 {
+		s.CurrentStage = s.ActiveStages[0].Key
+		s.StageStartedAt = s.ActiveStages[0].StartedAt
+	}
+	for _, active := range s.ActiveStages {
+		for _, approver := range active.PendingApprovers {
+			if !containsKey(s.PendingApprovers, approver) {
+				s.PendingApprovers = append(s.PendingApprovers, approver)
+			}
+		}
+		s.PendingItems = append(s.PendingItems, active.PendingItems...)
+	}
+	s.rebuildTimeline()
+}
+
+// rebuildTimeline 时间线 = 已执行阶段 + 尚未开始的阶段（按配置顺序）
+func (s *WorkflowState) rebuildTimeline() {
+	timeline := make([]StageTimeline, 0, len(s.history)+len(s.waiting))
+	timeline = append(timeline, s.history...)
+	for _, stage := range s.stages {
+		if containsKey(s.waiting, stage.Key) {
+			timeline = append(timeline, StageTimeline{
+				Stage:  stage.Name,
+				Key:    stage.Key,
+				Status: "pending",
+			})
+		}
+	}
+	s.Timeline = timeline
+}
+
+// activeEntry 阶段进行中的时间线记录
+func (s *WorkflowState) activeEntry(stageKey string) *StageTimeline {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Key == stageKey && s.history[i].Status == "in_progress" {
+			return &s.history[i]
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func removeKey(keys []string, key string) []string {
+	result := keys[:0:0]
+	for _, k := range keys {
+		if k != key {
+			result = append(result, k)
+		}
+	}
+	return result
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// ============================================================
+// 存储
+// API、Worker 和 Activity 通过 Store 读写流程配置、条目、版本和历史，不直接使用 gorm：
+//   MySQL、PostgreSQL 和 SQLite 共用一套 gorm 实现，差异只在连接方式
+//   SQLite 适合本地开发和演示，只允许一个连接，写操作依次进行
+//   表结构由 migrate 子命令按版本迁移，见 migrations.go
+// ============================================================
+
+// 数据库驱动
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// FlowConfigStore 流程配置和修订
+type FlowConfigStore interface {
+	GetFlowConfigs(includeArchived bool) ([]FlowConfig, error)
+	GetDefaultFlowConfig() (*FlowConfig, error)
+	GetEmergencyFlowConfig() (*FlowConfig, error)
+	SetEmergencyFlowConfig(id uint) error
+	GetFlowConfig(id uint) (*FlowConfig, error)
+	CreateFlowConfig(config *FlowConfig) error
+	UpdateFlowConfig(config *FlowConfig) error
+	ArchiveFlowConfig(id uint) error
+	GetFlowRevisions(flowConfigID uint) ([]FlowRevision, error)
+	GetFlowRevision(flowConfigID uint, revision int) (*FlowRevision, error)
+	GetFlowRevisionByID(id uint) (*FlowRevision, error)
+}
+
+// ItemStore 条目、条目状态变更和挂起
+type ItemStore interface {
+	ListItems(q ItemQuery) (*ItemPage, error)
+	GetItemByID(id string) (*ItemModel, error)
+	CreateItem(item *ItemModel) error
+	UpdateItem(itemID string, revision int, changes map[string]interface{}) (*ItemModel, error)
+	CloseItem(itemID string, revision int, req ItemTransitionRequest) error
+	DeleteItem(itemID string, revision int) error
+	ImportItems(rows []ItemImportRow, dryRun bool) ([]ItemImportResult, error)
+	TransitionItems(req ItemTransitionRequest) error
+	GetItemTransitions(itemID string) ([]ItemTransitionModel, error)
+	UpdateItemTestResult(itemID, stage, testResult string) error
+	SuspendVersionItems(versionID, stage string, reasons map[string]string) error
+	GetSuspensionsByVersion(versionID string) ([]SuspensionModel, error)
+	GetSuspensionByID(id uint) (*SuspensionModel, error)
+	UpdateSuspension(suspension *SuspensionModel) error
+}
+
+// VersionStore 版本和发布说明
+type VersionStore interface {
+	ListVersions(q VersionQuery) (*VersionPage, error)
+	GetVersionByID(id string) (*VersionModel, error)
+	CreateVersionWithItems(version *VersionModel, itemIDs []string) ([]ItemRejection, error)
+	SetVersionWorkflowID(versionID, workflowID string) error
+	UpdateVersionStatus(versionID, status string) error
+	RestoreVersionItems(versionID string, items []UpgradeItem) error
+	FinishVersion(result UpgradeWorkflowResult, completedAt time.Time) error
+	SaveReleaseNote(note *ReleaseNoteModel) error
+	GetReleaseNote(versionID string) (*ReleaseNoteModel, error)
+}
+
+// HistoryStore 阶段历史和审计记录
+type HistoryStore interface {
+	StartStage(t StageTransition) error
+	FinishStage(t StageTransition) error
+	GetStageHistory(versionID string) ([]StageHistoryModel, error)
+	CreateAudit(event AuditEvent) error
+	GetAudits(versionID, stage string) ([]AuditModel, error)
+}
+
+// PreferenceStore 用户通知偏好
+type PreferenceStore interface {
+	GetNotifyPreference(user string) (*NotifyPreference, error)
+	SaveNotifyPreference(pref *NotifyPreference) error
+}
+
+// Store 业务数据存储
+type Store interface {
+	FlowConfigStore
+	ItemStore
+	VersionStore
+	HistoryStore
+	PreferenceStore
+
+	// Migrate 执行未执行的迁移，可以重复执行
+	Migrate() error
+	// CheckSchema 检查表结构是否为当前程序的版本
+	CheckSchema() error
+	// InitDemoData 初始化演示数据，已有条目时不处理
+	InitDemoData() error
+	Close() error
+}
+
+// store 全局存储，由 openStore 设置
+var store Store
+
+// gormStore 基于 gorm 的存储实现
+type gormStore struct {
+	db     *gorm.DB
+	driver string
+}
+
+// openStore 按配置连接数据库
+func openStore(cfg DatabaseConfig) (*gormStore, error) {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case DriverMySQL:
+		dialector = mysql.Open(cfg.DSN())
+	case DriverPostgres:
+		dialector = postgres.Open(cfg.DSN())
+	case DriverSQLite:
+		dialector = sqlite.Open(cfg.DSN())
+	default:
+		return nil, fmt.Errorf("不支持的数据库驱动 %s", cfg.Driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver == DriverSQLite {
+		// SQLite 同时只能有一个写事务，多个连接并发写入时会返回 database is locked
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	logger.Info("数据库连接成功", zap.String("driver", cfg.Driver))
+	return &gormStore{db: db, driver: cfg.Driver}, nil
+}
+
+func (s *gormStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
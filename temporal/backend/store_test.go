@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// newTestStore 在临时目录中创建 SQLite 存储，未执行迁移
+func newTestStore(t *testing.T) *gormStore {
+	t.Helper()
+	logger = zap.NewNop()
+	s, err := openStore(DatabaseConfig{Driver: DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newMigratedStore 创建已迁移到最新版本的存储
+func newMigratedStore(t *testing.T) *gormStore {
+	t.Helper()
+	s := newTestStore(t)
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	return s
+}
+
+// sqliteSchema 每个表的字段和索引，用于比较两个数据库的表结构
+func sqliteSchema(t *testing.T, s *gormStore) map[string][]string {
+	t.Helper()
+	var tables []string
+	if err := s.db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	schema := make(map[string][]string)
+	for _, table := range tables {
+		var columns []struct {
+			Name      string
+			Type      string
+			NotNull   int `gorm:"column:notnull"`
+			DfltValue *string
+			Pk        int
+		}
+		s.db.Raw("PRAGMA table_info(" + table + ")").Scan(&columns)
+		for _, c := range columns {
+			def := ""
+			if c.DfltValue != nil {
+				def = *c.DfltValue
+			}
+			schema[table] = append(schema[table], fmt.Sprintf("column %s %s notnull=%d default=%s pk=%d", c.Name, c.Type, c.NotNull, def, c.Pk))
+		}
+
+		var indexes []struct {
+			Name   string
+			Unique int
+		}
+		s.db.Raw("PRAGMA index_list(" + table + ")").Scan(&indexes)
+		for _, index := range indexes {
+			var columns []string
+			s.db.Raw("SELECT name FROM pragma_index_info(?)", index.Name).Scan(&columns)
+			schema[table] = append(schema[table], fmt.Sprintf("index %s unique=%d %v", index.Name, index.Unique, columns))
+		}
+		sort.Strings(schema[table])
+	}
+	return schema
+}
+
+// assertSchemaMatchesModels 迁移后的表结构应与业务模型一致
+func assertSchemaMatchesModels(t *testing.T, migrated *gormStore) {
+	t.Helper()
+	models := newTestStore(t)
+	err := models.db.AutoMigrate(&SchemaMigration{}, &FlowConfig{}, &FlowRevision{}, &ItemModel{}, &VersionModel{},
+		&SuspensionModel{}, &StageHistoryModel{}, &AuditModel{}, &NotifyPreference{}, &ItemTransitionModel{},
+		&SequenceModel{}, &ReleaseNoteModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := sqliteSchema(t, migrated), sqliteSchema(t, models)
+	for table := range want {
+		if !reflect.DeepEqual(got[table], want[table]) {
+			t.Errorf("表 %s 与模型不一致\n迁移: %v\n模型: %v", table, got[table], want[table])
+		}
+	}
+	for table := range got {
+		if _, ok := want[table]; !ok {
+			t.Errorf("迁移创建了模型中没有的表 %s", table)
+		}
+	}
+}
+
+// ============================================================
+// 迁移
+// ============================================================
+
+func TestMigrateFromEmpty(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.CheckSchema(); err == nil {
+		t.Fatal("空数据库应该需要迁移")
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	if err := s.CheckSchema(); err != nil {
+		t.Fatalf("迁移后表结构检查失败: %v", err)
+	}
+	// 重复执行不做任何事
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("重复迁移失败: %v", err)
+	}
+	assertSchemaMatchesModels(t, s)
+
+	configs, err := s.GetFlowConfigs(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("默认流程配置数量为 %d，应为 2", len(configs))
+	}
+	if _, err := s.GetEmergencyFlowConfig(); err != nil {
+		t.Fatalf("没有紧急流程: %v", err)
+	}
+}
+
+// TestMigrateFromEachVersion 从每个已发布的版本升级到最新版本
+func TestMigrateFromEachVersion(t *testing.T) {
+	for _, m := range migrations[:len(migrations)-1] {
+		t.Run(fmt.Sprintf("v%d", m.version), func(t *testing.T) {
+			s := newTestStore(t)
+			if err := s.migrateTo(m.version); err != nil {
+				t.Fatalf("迁移到 %d 失败: %v", m.version, err)
+			}
+			if version, _ := s.schemaVersion(); version != m.version {
+				t.Fatalf("表结构版本为 %d，应为 %d", version, m.version)
+			}
+			if err := s.CheckSchema(); err == nil {
+				t.Fatal("旧版本的表结构应该需要迁移")
+			}
+			if err := s.Migrate(); err != nil {
+				t.Fatalf("从 %d 迁移失败: %v", m.version, err)
+			}
+			if err := s.CheckSchema(); err != nil {
+				t.Fatal(err)
+			}
+			assertSchemaMatchesModels(t, s)
+		})
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	s := newMigratedStore(t)
+	if err := s.db.Create(&SchemaMigration{Version: latestSchemaVersion() + 1, Name: "未来的迁移"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err == nil {
+		t.Fatal("数据库版本高于程序时应拒绝迁移")
+	}
+	if err := s.CheckSchema(); err == nil {
+		t.Fatal("数据库版本高于程序时应拒绝启动")
+	}
+}
+
+// ============================================================
+// 版本创建
+// ============================================================
+
+// createTestItem 创建审核完成的条目
+func createTestItem(t *testing.T, s *gormStore, id string) {
+	t.Helper()
+	item := ItemModel{ID: id, Name: "条目 " + id, Type: "需求", Status: ItemStatusAuditComplete}
+	if err := s.CreateItem(&item); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateVersionWithItemsConflict(t *testing.T) {
+	s := newMigratedStore(t)
+	for _, id := range []string{"I1", "I2", "I3"} {
+		createTestItem(t, s, id)
+	}
+
+	first := VersionModel{ID: "V1", Name: "版本1", Status: "running", ItemIDs: `["I1","I2"]`}
+	rejections, err := s.CreateVersionWithItems(&first, []string{"I1", "I2"})
+	if err != nil || len(rejections) > 0 {
+		t.Fatalf("创建版本失败: %v %v", err, rejections)
+	}
+
+	// I2 已被 V1 占用，I4 不存在，I3 重复；整个版本不创建，I3 不被占用
+	second := VersionModel{ID: "V2", Name: "版本2", Status: "running"}
+	rejections, err = s.CreateVersionWithItems(&second, []string{"I2", "I3", "I4", "I3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]string)
+	for _, r := range rejections {
+		reasons[r.ItemID] = r.Reason
+	}
+	if reasons["I2"] != "已被版本 V1 占用" || reasons["I4"] != "条目不存在" || reasons["I3"] != "条目重复" {
+		t.Fatalf("拒绝原因不正确: %v", rejections)
+	}
+	if _, err := s.GetVersionByID("V2"); err == nil {
+		t.Fatal("有条目被拒绝时不应创建版本")
+	}
+	if item, _ := s.GetItemByID("I3"); item.VersionID != "" {
+		t.Fatalf("条目 I3 不应被占用，当前版本 %s", item.VersionID)
+	}
+
+	// 版本结束后释放条目，可以加入新版本
+	if err := s.FinishVersion(UpgradeWorkflowResult{VersionID: "V1", Status: "completed"}, first.CreatedAt); err != nil {
+		t.Fatal(err)
+	}
+	third := VersionModel{ID: "V3", Name: "版本3", Status: "running"}
+	rejections, err = s.CreateVersionWithItems(&third, []string{"I2", "I3"})
+	if err != nil || len(rejections) > 0 {
+		t.Fatalf("释放后创建版本失败: %v %v", err, rejections)
+	}
+	if item, _ := s.GetItemByID("I2"); item.VersionID != "V3" {
+		t.Fatalf("条目 I2 应被 V3 占用，当前为 %q", item.VersionID)
+	}
+}
+
+// ============================================================
+// 条目乐观锁
+// ============================================================
+
+func TestItemRevisionCheck(t *testing.T) {
+	s := newMigratedStore(t)
+	item := ItemModel{ID: "I1", Name: "条目", Type: "需求", Status: ItemStatusRegistered}
+	if err := s.CreateItem(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := s.UpdateItem("I1", 0, map[string]interface{}{"name": "新名称"})
+	if err != nil {
+		t.Fatalf("修改失败: %v", err)
+	}
+	if updated.Revision != 1 || updated.Name != "新名称" {
+		t.Fatalf("修改后 revision=%d name=%s", updated.Revision, updated.Name)
+	}
+
+	// 使用旧的 revision 修改、关闭、删除都被拒绝
+	var conflict *RevisionConflictError
+	if _, err := s.UpdateItem("I1", 0, map[string]interface{}{"name": "覆盖"}); !errors.As(err, &conflict) {
+		t.Fatalf("旧 revision 修改应返回冲突，实际 %v", err)
+	}
+	if conflict.Expected != 0 || conflict.Current != 1 {
+		t.Fatalf("冲突信息不正确: %+v", conflict)
+	}
+	if err := s.CloseItem("I1", 0, ItemTransitionRequest{To: ItemStatusClosed, Reason: "不需要"}); !errors.As(err, &conflict) {
+		t.Fatalf("旧 revision 关闭应返回冲突，实际 %v", err)
+	}
+	if err := s.DeleteItem("I1", 0); !errors.As(err, &conflict) {
+		t.Fatalf("旧 revision 删除应返回冲突，实际 %v", err)
+	}
+	if current, _ := s.GetItemByID("I1"); current.Name != "新名称" || current.Revision != 1 {
+		t.Fatalf("冲突的修改不应生效: name=%s revision=%d", current.Name, current.Revision)
+	}
+
+	// 工作流写入测试结果也会增加 revision
+	if err := s.UpdateItemTestResult("I1", StageBTETest, TestResultPassed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteItem("I1", 1); !errors.As(err, &conflict) {
+		t.Fatalf("测试结果写入后旧 revision 应冲突，实际 %v", err)
+	}
+	if err := s.DeleteItem("I1", 2); err != nil {
+		t.Fatalf("使用最新 revision 删除失败: %v", err)
+	}
+}
+
+// ============================================================
+// 挂起
+// ============================================================
+
+// TestSuspendVersionItemsRetry Activity 提交后超时重试，不重复挂起
+func TestSuspendVersionItemsRetry(t *testing.T) {
+	s := newMigratedStore(t)
+	for _, id := range []string{"I1", "I2"} {
+		createTestItem(t, s, id)
+	}
+	version := VersionModel{ID: "V1", Name: "版本1", Status: "running", ItemIDs: `["I1","I2"]`}
+	if _, err := s.CreateVersionWithItems(&version, []string{"I1", "I2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reasons := map[string]string{"I1": "BTE 测试不通过"}
+	for i := 0; i < 2; i++ {
+		if err := s.SuspendVersionItems("V1", StageBTETest, reasons); err != nil {
+			t.Fatalf("第 %d 次挂起失败: %v", i+1, err)
+		}
+	}
+
+	suspensions, err := s.GetSuspensionsByVersion("V1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suspensions) != 1 || suspensions[0].ItemID != "I1" {
+		t.Fatalf("挂起记录应只有 I1 一条，实际 %+v", suspensions)
+	}
+	transitions, _ := s.GetItemTransitions("I1")
+	if len(transitions) != 1 {
+		t.Fatalf("I1 应只有一次状态变更，实际 %d", len(transitions))
+	}
+	if v, _ := s.GetVersionByID("V1"); v.ItemIDs != `["I2"]` {
+		t.Fatalf("版本条目应为 [I2]，实际 %s", v.ItemIDs)
+	}
+}
+
+// ============================================================
+// 审计记录
+// ============================================================
+
+// TestCreateAuditDedupe 重试写入同一事件只保留一条，同一时间不同审批人的会签都保留
+func TestCreateAuditDedupe(t *testing.T) {
+	s := newMigratedStore(t)
+	now := time.Now()
+	events := []AuditEvent{
+		{VersionID: "V1", Stage: StageProdFinalize, EventType: AuditApprove, Operator: "张三", Passed: true, OccurredAt: now},
+		{VersionID: "V1", Stage: StageProdFinalize, EventType: AuditApprove, Operator: "张三", Passed: true, OccurredAt: now},
+		{VersionID: "V1", Stage: StageProdFinalize, EventType: AuditApprove, Operator: "李四", Passed: true, OccurredAt: now},
+	}
+	for _, event := range events {
+		if err := s.CreateAudit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	audits, err := s.GetAudits("V1", StageProdFinalize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 2 || audits[0].Operator == audits[1].Operator {
+		t.Fatalf("应有张三、李四两条审批记录，实际 %+v", audits)
+	}
+}
+
+// TestStartStageRetryAndQueryError Activity 重试时不重复记录进入阶段，查询失败时返回错误而不是插入
+func TestStartStageRetryAndQueryError(t *testing.T) {
+	s := newMigratedStore(t)
+	version := VersionModel{ID: "V1", Name: "版本1", Status: "running", ItemIDs: `[]`}
+	if _, err := s.CreateVersionWithItems(&version, nil); err != nil {
+		t.Fatal(err)
+	}
+	start := StageTransition{VersionID: "V1", Stage: StageBTEConfirm, Iteration: 1, Timestamp: time.Now()}
+	for i := 0; i < 2; i++ {
+		if err := s.StartStage(start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if history, _ := s.GetStageHistory("V1"); len(history) != 1 {
+		t.Fatalf("重试后阶段记录 %d 条，期望 1 条", len(history))
+	}
+
+	errQuery := errors.New("查询失败")
+	if err := s.db.Callback().Query().Before("gorm:query").Register("test:fail_stage_history", func(db *gorm.DB) {
+		if db.Statement.Table == "upgrade_stage_history" {
+			db.AddError(errQuery)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	start.Timestamp = start.Timestamp.Add(time.Hour)
+	start.Iteration = 2
+	if err := s.StartStage(start); !errors.Is(err, errQuery) {
+		t.Fatalf("查询失败时返回 %v，期望查询错误", err)
+	}
+	s.db.Callback().Query().Remove("test:fail_stage_history")
+	if history, _ := s.GetStageHistory("V1"); len(history) != 1 {
+		t.Fatalf("查询失败时不应插入阶段记录，当前 %d 条", len(history))
+	}
+}
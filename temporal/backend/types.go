@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // ============================================================
 // 升级流程核心数据结构
 // ============================================================
@@ -13,7 +15,8 @@ type UpgradeVersion struct {
 	BTETester    string   `json:"bte_tester"`    // BTE测试负责人
 	GrayTester   string   `json:"gray_tester"`   // 灰度测试负责人
 	ProdTester   string   `json:"prod_tester"`   // 生产测试负责人
-	IsUrgent     bool     `json:"is_urgent"`     // 是否紧急版本（跳过灰度）
+	IsUrgent     bool     `json:"is_urgent"`     // 是否紧急版本（紧急流程、紧急时限）
+	UrgentReason string   `json:"urgent_reason"` // 紧急升级理由
 	Status       string   `json:"status"`        // 状态
 	CurrentStage string   `json:"current_stage"` // 当前阶段
 	ItemIDs      []string `json:"item_ids"`      // 包含的条目ID
@@ -89,6 +92,8 @@ type UpgradeWorkflowRequest struct {
 	Version      UpgradeVersion `json:"version"`
 	Items        []UpgradeItem  `json:"items"`
 	FlowConfigID uint           `json:"flow_config_id"`
+
+	FlowRevisionID uint `json:"flow_revision_id"` // 固定的流程配置修订，为空时读取流程配置（修订功能之前启动的流程）
 }
 
 // UpgradeWorkflowResult 升级流程结果
@@ -106,6 +111,139 @@ type StageResult struct {
 	PassedItems []string `json:"passed_items"` // 通过的条目
 	FailedItems []string `json:"failed_items"` // 失败的条目
 	Message     string   `json:"message"`
+
+	FailReasons map[string]string `json:"fail_reasons"` // 失败条目的BUG描述
+}
+
+// SuspendItemsRequest 挂起条目请求
+type SuspendItemsRequest struct {
+	VersionID string            `json:"version_id"`
+	Stage     string            `json:"stage"`
+	Reasons   map[string]string `json:"reasons"` // 条目ID -> 挂起原因
+}
+
+// AuditEvent 审计事件，写入后不可修改
+type AuditEvent struct {
+	VersionID  string    `json:"version_id"`
+	Stage      string    `json:"stage"`
+	EventType  string    `json:"event_type"` // approve/reject/test/timeout/auto_pass/suspend_confirm/escalate/pause/resume/cancel
+	Operator   string    `json:"operator"`
+	ItemID     string    `json:"item_id,omitempty"`
+	Passed     bool      `json:"passed"`
+	Comment    string    `json:"comment"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// ActiveStage 进行中的阶段，并行分支时可能有多个
+type ActiveStage struct {
+	Key              string   `json:"key"`
+	Name             string   `json:"name"`
+	StartedAt        string   `json:"started_at"`
+	PendingApprovers []string `json:"pending_approvers"` // 尚未表态的审批人
+	PendingItems     []string `json:"pending_items"`     // 测试阶段未提交结果的条目
+
+	ApprovalMode      string           `json:"approval_mode,omitempty"`      // 会签方式
+	RequiredApprovals int              `json:"required_approvals,omitempty"` // 需要的通过人数
+	Signatures        []ApprovalAction `json:"signatures,omitempty"`         // 已表态的审批记录
+	Deadline          string           `json:"deadline,omitempty"`           // 审批截止时间
+	EscalatedTo       string           `json:"escalated_to,omitempty"`       // 超时升级后的处理人
+}
+
+// WorkflowState 工作流实时状态，通过 Query 暴露给状态 API
+// CurrentStage 等单阶段字段取最早进入的进行中阶段，PendingApprovers 为所有进行中阶段的汇总
+type WorkflowState struct {
+	VersionID        string                       `json:"version_id"`
+	Status           string                       `json:"status"` // running/paused/completed/failed/cancelled
+	CurrentStage     string                       `json:"current_stage"`
+	StageStartedAt   string                       `json:"stage_started_at"`
+	PendingApprovers []string                     `json:"pending_approvers"`
+	PendingItems     []string                     `json:"pending_items"`
+	ActiveStages     []ActiveStage                `json:"active_stages"`
+	Approvals        []ApprovalAction             `json:"approvals"`    // 审批/驳回记录
+	TestResults      []TestSubmission             `json:"test_results"` // 测试提交记录
+	ItemResults      map[string]map[string]string `json:"item_results"` // 条目ID -> 阶段 -> 测试结果
+	Iterations       map[string]int               `json:"iterations"`   // 阶段 -> 进入次数（含退回后重新进入）
+	Timeline         []StageTimeline              `json:"timeline"`     // 已执行阶段 + 待执行阶段
+
+	Pause *PauseInfo `json:"pause,omitempty"` // 暂停信息，未暂停时为空
+
+	stages  []StageConfig
+	history []StageTimeline
+	waiting []string // 尚未开始的阶段
+}
+
+// PauseInfo 版本暂停信息
+type PauseInfo struct {
+	PausedBy string `json:"paused_by"`
+	Reason   string `json:"reason"`
+	PausedAt string `json:"paused_at"`
+}
+
+// VersionControl 取消/暂停/恢复版本的 Signal 内容
+type VersionControl struct {
+	Operator  string `json:"operator"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ItemTransitionRequest 条目状态变更请求
+type ItemTransitionRequest struct {
+	VersionID string   `json:"version_id"`
+	Stage     string   `json:"stage"`
+	ItemIDs   []string `json:"item_ids"`
+	To        string   `json:"to"`
+	Operator  string   `json:"operator"`
+	Reason    string   `json:"reason"`
+}
+
+// RestoreItemsRequest 取消版本时恢复条目状态的请求
+type RestoreItemsRequest struct {
+	VersionID string        `json:"version_id"`
+	Items     []UpgradeItem `json:"items"` // 版本开始时的条目快照
+}
+
+// VersionStatusUpdate 版本运行状态变更
+type VersionStatusUpdate struct {
+	VersionID string `json:"version_id"`
+	Status    string `json:"status"` // running/paused
+}
+
+// StageTransition 阶段变更（进入/结束）
+type StageTransition struct {
+	VersionID string    `json:"version_id"`
+	Stage     string    `json:"stage"`
+	StageName string    `json:"stage_name"`
+	Status    string    `json:"status"`  // in_progress/completed/failed/returned
+	Outcome   string    `json:"outcome"` // 结束方式，见 StageOutcome 常量
+	Operator  string    `json:"operator"`
+	Message   string    `json:"message"`
+	Iteration int       `json:"iteration"` // 第几次进入该阶段
+	Timestamp time.Time `json:"timestamp"` // 工作流时间
+}
+
+// Notification 通知，接收人按各自的渠道偏好接收
+type Notification struct {
+	VersionID  string            `json:"version_id"`
+	Stage      string            `json:"stage"`
+	StageName  string            `json:"stage_name"`
+	Event      string            `json:"event"`      // 通知事件，见 NotifyEvent 常量
+	Recipients []string          `json:"recipients"` // 接收人
+	Message    string            `json:"message"`    // 默认消息
+	Template   string            `json:"template"`   // 阶段配置的模板，为空时使用默认消息
+	Vars       map[string]string `json:"vars"`       // 模板附加变量
+}
+
+// EscalateStageRequest 审批超时升级请求
+type EscalateStageRequest struct {
+	VersionID string `json:"version_id"`
+	Stage     string `json:"stage"`
+	To        string `json:"to"` // 升级对象
+}
+
+// FinishVersionRequest 版本结束请求
+type FinishVersionRequest struct {
+	Result      UpgradeWorkflowResult `json:"result"`
+	CompletedAt time.Time             `json:"completed_at"`
 }
 
 // ============================================================
@@ -121,6 +259,7 @@ type CreateVersionRequest struct {
 	GrayTester   string   `json:"gray_tester"`
 	ProdTester   string   `json:"prod_tester"`
 	IsUrgent     bool     `json:"is_urgent"`
+	UrgentReason string   `json:"urgent_reason"`
 	ItemIDs      []string `json:"item_ids"`
 }
 
@@ -156,10 +295,14 @@ type VersionStatusResponse struct {
 // StageTimeline 阶段时间线
 type StageTimeline struct {
 	Stage       string `json:"stage"`
-	Status      string `json:"status"` // pending/in_progress/completed/skipped
+	Key         string `json:"key"`
+	Status      string `json:"status"`    // pending/in_progress/completed/skipped/failed/returned
+	Iteration   int    `json:"iteration"` // 第几次进入该阶段
 	StartedAt   string `json:"started_at"`
 	CompletedAt string `json:"completed_at"`
 	Operator    string `json:"operator"`
+
+	Audits []AuditEvent `json:"audits,omitempty"` // 阶段内的审计记录
 }
 
 // ItemListResponse 条目列表响应
@@ -181,6 +324,31 @@ type CreateItemRequest struct {
 	TestCases     []string `json:"test_cases"`
 }
 
+// UpdateItemRequest 修改条目请求，只修改请求中出现的字段
+type UpdateItemRequest struct {
+	Revision *int `json:"revision"` // 读取条目时的 revision
+
+	Name          *string   `json:"name"`
+	Type          *string   `json:"type"`
+	RequirementID *string   `json:"requirement_id"`
+	Developer     *string   `json:"developer"`
+	Tester        *string   `json:"tester"`
+	ItemOwner     *string   `json:"item_owner"`
+	HasScript     *bool     `json:"has_script"`
+	HasCache      *bool     `json:"has_cache"`
+	HasRestart    *bool     `json:"has_restart"`
+	TestCases     *[]string `json:"test_cases"`
+	BTEResult     *string   `json:"bte_result"`
+	GrayResult    *string   `json:"gray_result"`
+	ProdResult    *string   `json:"prod_result"`
+}
+
+// CloseItemRequest 关闭条目请求
+type CloseItemRequest struct {
+	Revision *int   `json:"revision"` // 读取条目时的 revision
+	Reason   string `json:"reason"`
+}
+
 // ============================================================
 // 常量定义
 // ============================================================
@@ -203,6 +371,24 @@ const (
 	StageCompleted    = "completed"     // 已完成
 )
 
+// Query 名称
+const (
+	QueryWorkflowState    = "workflow_state"
+	QueryCurrentStage     = "current_stage"
+	QueryActiveStages     = "active_stages"
+	QueryStageStartedAt   = "stage_started_at"
+	QueryPendingApprovers = "pending_approvers"
+	QueryApprovalHistory  = "approval_history"
+	QueryItemResults      = "item_results"
+)
+
+// 版本控制 Signal 名称
+const (
+	SignalCancel = "version-cancel"
+	SignalPause  = "version-pause"
+	SignalResume = "version-resume"
+)
+
 // 条目状态
 const (
 	ItemStatusRegistered    = "已登记"
@@ -215,6 +401,49 @@ const (
 	ItemStatusSuspended     = "挂起"
 )
 
+// 阶段结束方式
+const (
+	StageOutcomeApproved = "approved"  // 审批通过
+	StageOutcomeRejected = "rejected"  // 审批驳回
+	StageOutcomeAutoPass = "auto_pass" // 超时自动通过
+	StageOutcomeTimeout  = "timeout"   // 超时
+	StageOutcomePassed   = "passed"    // 测试通过
+	StageOutcomeFailed   = "failed"    // 测试不通过/执行失败
+)
+
+// 审计事件类型
+const (
+	AuditApprove        = "approve"
+	AuditReject         = "reject"
+	AuditTest           = "test"
+	AuditTimeout        = "timeout"
+	AuditAutoPass       = "auto_pass"
+	AuditSuspendConfirm = "suspend_confirm"
+	AuditEscalate       = "escalate"
+	AuditPause          = "pause"
+	AuditResume         = "resume"
+	AuditCancel         = "cancel"
+)
+
+// 测试不通过处理策略
+const (
+	FailPolicyFail    = "fail"    // 整个版本失败（默认）
+	FailPolicySuspend = "suspend" // 挂起不通过条目，其余条目继续
+)
+
+// 审批会签方式
+const (
+	ApprovalModeAny    = "any"    // 任一审批人通过（默认）
+	ApprovalModeAll    = "all"    // 所有审批人通过
+	ApprovalModeQuorum = "quorum" // 至少 N 个审批人通过
+)
+
+// 审批超时处理策略
+const (
+	EscalationFail     = "fail"     // 阶段失败（默认）
+	EscalationEscalate = "escalate" // 升级到版本负责人并延长一次，再次超时失败
+)
+
 // 测试结果
 const (
 	TestResultPending = "待测试"
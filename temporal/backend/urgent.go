@@ -0,0 +1,63 @@
+package main
+
+// ============================================================
+// 紧急版本
+// 紧急版本未指定流程配置时使用指定的紧急流程，并按紧急时限执行：
+//   各阶段使用配置的 urgent_timeout，未配置时按比例压缩超时时间
+//   流程开始前由版本负责人确认紧急升级理由
+// 紧急版本在版本列表和通知中醒目标识
+// ============================================================
+
+// StageUrgentJustify 紧急升级理由确认阶段，紧急版本自动加在流程最前面
+const StageUrgentJustify = "urgent_justify"
+
+// 紧急时限
+const (
+	urgentTimeoutPercent = 25 // 未配置 urgent_timeout 时，超时时间压缩为原来的百分比
+	urgentMinTimeout     = 1  // 压缩后的最短超时时间（小时）
+	urgentJustifyTimeout = 4  // 紧急升级理由确认的超时时间（小时）
+)
+
+// urgentTitlePrefix 紧急版本通知标题前缀
+const urgentTitlePrefix = "【紧急】"
+
+// urgentStages 紧急版本执行的阶段：在最前面加上理由确认阶段，各阶段使用紧急时限
+// 理由确认阶段没有依赖，原来的第一个阶段未声明依赖时依赖它，因此所有阶段都在确认之后开始
+func urgentStages(stages []StageConfig) []StageConfig {
+	justify := StageConfig{
+		Key:     StageUrgentJustify,
+		Name:    "紧急升级理由确认",
+		Type:    "approval",
+		Enabled: true,
+		Timeout: urgentJustifyTimeout,
+		Order:   0,
+		Roles:   []string{RoleVersionOwner},
+		Templates: map[string]string{
+			NotifyEventEnter: "紧急版本 {{.Version.Name}} 等待版本负责人确认紧急升级理由：{{.Version.UrgentReason}}",
+		},
+	}
+
+	result := make([]StageConfig, 0, len(stages)+1)
+	result = append(result, justify)
+	for _, stage := range stages {
+		if stage.UrgentTimeout > 0 {
+			stage.Timeout = stage.UrgentTimeout
+		} else {
+			stage.Timeout = compressHours(stage.Timeout)
+		}
+		if stage.ExtendHours > 0 {
+			stage.ExtendHours = compressHours(stage.ExtendHours)
+		}
+		result = append(result, stage)
+	}
+	return result
+}
+
+// compressHours 按紧急比例压缩时长，不少于最短超时时间
+func compressHours(hours int) int {
+	compressed := hours * urgentTimeoutPercent / 100
+	if compressed < urgentMinTimeout {
+		return urgentMinTimeout
+	}
+	return compressed
+}
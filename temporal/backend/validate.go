@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============================================================
+// 流程配置校验
+// 收集所有问题后一次返回，字段路径与请求 JSON 对应，如 stages[2].timeout
+// ============================================================
+
+// FieldError 字段校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors 校验错误列表
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fe.Field+": "+fe.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// flowValidator 收集校验错误
+type flowValidator struct {
+	errs ValidationErrors
+}
+
+func (v *flowValidator) add(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// result 没有错误时返回 nil，避免返回带类型的 nil error
+func (v *flowValidator) result() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+var (
+	stageKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	stageTypes      = []string{"approval", "test", "prepare"}
+	failPolicies    = []string{"", FailPolicyFail, FailPolicySuspend}
+)
+
+// ValidateFlowConfig 校验流程配置名称和阶段
+func ValidateFlowConfig(name string, stages []StageConfig) error {
+	v := &flowValidator{}
+	if strings.TrimSpace(name) == "" {
+		v.add("name", "名称不能为空")
+	}
+	validateStages(v, stages)
+	return v.result()
+}
+
+// ValidateFlowStages 校验阶段配置，错误类型为 ValidationErrors
+func ValidateFlowStages(stages []StageConfig) error {
+	v := &flowValidator{}
+	validateStages(v, stages)
+	return v.result()
+}
+
+func validateStages(v *flowValidator, stages []StageConfig) {
+	if len(stages) == 0 {
+		v.add("stages", "至少需要一个阶段")
+		return
+	}
+
+	keys := make(map[string]int, len(stages))
+	enabled := 0
+	for i, stage := range stages {
+		field := fmt.Sprintf("stages[%d]", i)
+
+		switch {
+		case stage.Key == "":
+			v.add(field+".key", "标识不能为空")
+		case !stageKeyPattern.MatchString(stage.Key):
+			v.add(field+".key", "标识 %s 只能包含小写字母、数字和下划线，且以字母开头", stage.Key)
+		case stage.Key == StageUrgentJustify:
+			v.add(field+".key", "标识 %s 为紧急版本保留", stage.Key)
+		default:
+			if prev, ok := keys[stage.Key]; ok {
+				v.add(field+".key", "标识 %s 与 stages[%d] 重复", stage.Key, prev)
+			} else {
+				keys[stage.Key] = i
+			}
+		}
+		if strings.TrimSpace(stage.Name) == "" {
+			v.add(field+".name", "名称不能为空")
+		}
+		if !containsKey(stageTypes, stage.Type) {
+			v.add(field+".type", "类型 %s 无效，应为 approval/test/prepare", stage.Type)
+		}
+		if stage.Timeout <= 0 {
+			v.add(field+".timeout", "超时时间必须大于 0")
+		}
+		if stage.UrgentTimeout < 0 {
+			v.add(field+".urgent_timeout", "紧急超时时间不能为负数")
+		} else if stage.UrgentTimeout > stage.Timeout && stage.Timeout > 0 {
+			v.add(field+".urgent_timeout", "紧急超时时间不能超过超时时间 %d 小时", stage.Timeout)
+		}
+		if stage.Order != i+1 {
+			v.add(field+".order", "顺序 %d 与阶段位置不一致，应为 %d", stage.Order, i+1)
+		}
+		if stage.AutoPass && stage.Type != "approval" {
+			v.add(field+".auto_pass", "只有审批阶段可以超时自动通过")
+		}
+		if !containsKey(failPolicies, stage.OnFail) {
+			v.add(field+".on_fail", "不通过处理策略 %s 无效", stage.OnFail)
+		} else if stage.OnFail == FailPolicySuspend && stage.Type != "test" {
+			v.add(field+".on_fail", "只有测试阶段可以挂起不通过条目")
+		}
+		for j, role := range stage.Roles {
+			if !containsKey(allRoles, role) {
+				v.add(fmt.Sprintf("%s.roles[%d]", field, j), "角色 %s 无效", role)
+			}
+		}
+		if stage.MaxReturns < 0 {
+			v.add(field+".max_returns", "最大退回次数不能为负数")
+		}
+
+		validateApprovalMode(v, field, stage)
+		validateTimeoutPolicy(v, field, stage)
+		validateTemplates(v, field, stage)
+
+		if stage.Enabled {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		v.add("stages", "至少需要启用一个阶段")
+	}
+
+	// 依赖和退回阶段需要引用存在的阶段
+	for i, stage := range stages {
+		field := fmt.Sprintf("stages[%d]", i)
+		for j, dep := range stage.DependsOn {
+			if _, ok := keys[dep]; !ok {
+				v.add(fmt.Sprintf("%s.depends_on[%d]", field, j), "依赖的阶段 %s 不存在", dep)
+			} else if dep == stage.Key {
+				v.add(fmt.Sprintf("%s.depends_on[%d]", field, j), "不能依赖自身")
+			}
+		}
+		if stage.ReturnTo != "" {
+			if _, ok := keys[stage.ReturnTo]; !ok {
+				v.add(field+".return_to", "退回阶段 %s 不存在", stage.ReturnTo)
+			}
+		}
+	}
+	if len(v.errs) > 0 {
+		return
+	}
+
+	// 依赖图：循环依赖和退回阶段必须是前置阶段
+	g, err := buildStageGraph(stages)
+	if err != nil {
+		v.add("stages", "%v", err)
+		return
+	}
+	for i, stage := range stages {
+		if !stage.Enabled || stage.ReturnTo == "" {
+			continue
+		}
+		if _, ok := g.stages[stage.ReturnTo]; !ok || !g.isAncestor(stage.ReturnTo, stage.Key) {
+			v.add(fmt.Sprintf("stages[%d].return_to", i), "退回阶段 %s 必须是已启用的前置阶段", stage.ReturnTo)
+		}
+	}
+}
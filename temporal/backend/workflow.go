@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/worker"
@@ -12,7 +16,10 @@ import (
 	"go.uber.org/zap"
 )
 
-const TaskQueue = "upgrade-workflow-queue"
+// TaskQueue Temporal 任务队列，启动时按配置设置
+var TaskQueue = "upgrade-workflow-queue"
+
+var errTestTimeout = errors.New("测试超时")
 
 // ============================================================
 // 升级流程 Workflow（动态配置版本）
@@ -20,11 +27,6 @@ const TaskQueue = "upgrade-workflow-queue"
 // ============================================================
 
 func UpgradeWorkflow(ctx workflow.Context, req UpgradeWorkflowRequest) (UpgradeWorkflowResult, error) {
-	result := UpgradeWorkflowResult{
-		VersionID: req.Version.ID,
-		Status:    "running",
-	}
-
 	// Activity 配置
 	activityOptions := workflow.ActivityOptions{
 		StartToCloseTimeout: 5 * time.Minute,
@@ -38,120 +40,592 @@ func UpgradeWorkflow(ctx workflow.Context, req UpgradeWorkflowRequest) (UpgradeW
 
 	logger.Info("升级流程开始", zap.String("version", req.Version.Name), zap.Uint("flowConfigId", req.FlowConfigID))
 
+	result, err := executeStages(ctx, req)
+
+	// 持久化最终结果
+	finishReq := FinishVersionRequest{Result: result, CompletedAt: workflow.Now(ctx)}
+	if perr := workflow.ExecuteActivity(persistContext(ctx), FinishVersionActivity, finishReq).Get(ctx, nil); perr != nil {
+		logger.Error("版本结果持久化失败", zap.String("versionId", req.Version.ID), zap.Error(perr))
+		return result, err
+	}
+
+	if result.Status == "completed" {
+		// 知识沉淀：版本结果和条目释放持久化后生成发布说明，失败不影响流程结果
+		if aerr := workflow.ExecuteActivity(ctx, ArchiveKnowledgeActivity, req.Version.ID).Get(ctx, nil); aerr != nil {
+			logger.Warn("发布说明生成失败", zap.String("versionId", req.Version.ID), zap.Error(aerr))
+		}
+		logger.Info("升级流程完成", zap.String("version", req.Version.Name))
+	}
+	return result, err
+}
+
+// executeStages 按阶段依赖图执行各阶段
+// 依赖都已完成的阶段同时开始，在并行分支中执行；所有阶段完成后流程结束
+func executeStages(ctx workflow.Context, req UpgradeWorkflowRequest) (UpgradeWorkflowResult, error) {
+	result := UpgradeWorkflowResult{
+		VersionID: req.Version.ID,
+		Status:    "running",
+	}
+
 	// 获取流程配置
 	var stages []StageConfig
-	if err := workflow.ExecuteActivity(ctx, GetFlowConfigActivity, req.FlowConfigID).Get(ctx, &stages); err != nil {
+	var stagesFuture workflow.Future
+	if req.FlowRevisionID > 0 {
+		stagesFuture = workflow.ExecuteActivity(ctx, GetFlowRevisionActivity, req.FlowRevisionID)
+	} else {
+		stagesFuture = workflow.ExecuteActivity(ctx, GetFlowConfigActivity, req.FlowConfigID)
+	}
+	if err := stagesFuture.Get(ctx, &stages); err != nil {
 		result.Status = "failed"
 		result.Message = fmt.Sprintf("获取流程配置失败: %v", err)
 		return result, err
 	}
+	if req.Version.IsUrgent {
+		stages = urgentStages(stages)
+	}
+	graph, err := buildStageGraph(stages)
+	if err != nil {
+		result.Status = "failed"
+		result.Message = fmt.Sprintf("流程配置无效: %v", err)
+		return result, err
+	}
 
-	// 动态执行每个阶段
-	for _, stage := range stages {
-		if !stage.Enabled {
-			logger.Info("阶段已跳过", zap.String("stage", stage.Name))
-			continue
+	// 注册 Query，对外暴露实时状态
+	state := newWorkflowState(req.Version.ID, stages)
+	if err := registerQueries(ctx, state); err != nil {
+		result.Status = "failed"
+		result.Message = fmt.Sprintf("注册 Query 失败: %v", err)
+		return result, err
+	}
+
+	// 取消/暂停/恢复
+	control := listenVersionControl(ctx, state, req.Version)
+
+	done := make(map[string]bool)
+	running := make(map[string]workflow.CancelFunc)
+	cancelling := make(map[string]bool)
+	returns := make(map[string]int)
+	completions := workflow.NewBufferedChannel(ctx, len(graph.order))
+
+	// stopAll 流程失败或取消时结束所有进行中的分支，status 为 failed/cancelled
+	stopAll := func(status, message string) {
+		for _, key := range graph.order {
+			if cancel, ok := running[key]; ok {
+				cancel()
+				exitStage(ctx, state, req, graph.stages[key], "cancelled", stageExit{Message: message})
+			}
+		}
+		state.Status = status
+		result.Status = status
+		result.Message = message
+	}
+
+	for len(done) < len(graph.order) {
+		// 启动依赖都已完成的阶段
+		for _, key := range graph.order {
+			if done[key] || running[key] != nil || !graph.ready(key, done) {
+				continue
+			}
+			stage := graph.stages[key]
+			result.CurrentStage = stage.Key
+			enterStage(ctx, state, req, stage)
+			logger.Info("开始执行阶段",
+				zap.String("stage", stage.Name),
+				zap.String("type", stage.Type),
+				zap.Int("iteration", state.Iterations[stage.Key]))
+
+			// 发送通知
+			notify(ctx, stageNotification(req.Version, stage, NotifyEventEnter, stageApprovers(stage, req.Version),
+				fmt.Sprintf("版本 %s 进入【%s】阶段", req.Version.Name, stage.Name), nil))
+
+			stageCtx, cancel := workflow.WithCancel(ctx)
+			running[key] = cancel
+			workflow.Go(stageCtx, func(gctx workflow.Context) {
+				exit, err := runStage(gctx, state, &req, stage)
+				completions.Send(gctx, stageCompletion{Key: stage.Key, Exit: exit, Err: err})
+			})
 		}
 
-		result.CurrentStage = stage.Key
-		logger.Info("开始执行阶段", zap.String("stage", stage.Name), zap.String("type", stage.Type))
+		// 等待任一阶段结束或取消请求
+		var c stageCompletion
+		var cancelled bool
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(completions, func(ch workflow.ReceiveChannel, more bool) {
+			ch.Receive(ctx, &c)
+		})
+		selector.AddFuture(control.cancelled, func(f workflow.Future) {
+			cancelled = true
+		})
+		selector.Select(ctx)
 
-		// 发送通知
-		workflow.ExecuteActivity(ctx, NotifyActivity,
-			fmt.Sprintf("版本 %s 进入【%s】阶段", req.Version.Name, stage.Name))
+		if cancelled {
+			signal := *control.request
+			stopAll("cancelled", fmt.Sprintf("版本被 %s 取消: %s", signal.Operator, signal.Reason))
+			compensateCancel(ctx, state, req.Version, req.Items, signal)
+			return result, nil
+		}
 
-		// 根据阶段类型执行
-		var err error
-		switch stage.Type {
-		case "approval":
-			if stage.AutoPass {
-				err = waitForStageApprovalWithAutoPass(ctx, stage.Key, time.Duration(stage.Timeout)*time.Hour)
-			} else {
-				err = waitForStageApproval(ctx, stage.Key, time.Duration(stage.Timeout)*time.Hour)
-			}
-		case "prepare":
-			err = waitForStageApproval(ctx, stage.Key, time.Duration(stage.Timeout)*time.Hour)
-		case "test":
-			var testResult StageResult
-			testResult, err = executeTestStage(ctx, req, stage.Key)
-			if err == nil && !testResult.Passed {
-				result.Status = "failed"
-				result.Message = fmt.Sprintf("%s 未通过", stage.Name)
+		stage := graph.stages[c.Key]
+		delete(running, c.Key)
+
+		switch {
+		case cancelling[c.Key]:
+			// 退回时被取消的分支
+			delete(cancelling, c.Key)
+			exitStage(ctx, state, req, stage, "cancelled", stageExit{Message: "阶段被退回，分支取消"})
+
+		case c.Err != nil:
+			exit := c.Exit
+			exit.Message = c.Err.Error()
+			exitStage(ctx, state, req, stage, "failed", exit)
+			stopAll("failed", fmt.Sprintf("%s 失败: %v", stage.Name, c.Err))
+			return result, c.Err
+
+		case c.Exit.Outcome == StageOutcomeRejected || c.Exit.Outcome == StageOutcomeFailed:
+			if stage.ReturnTo == "" {
+				exitStage(ctx, state, req, stage, "failed", c.Exit)
+				stopAll("failed", fmt.Sprintf("%s 未通过", stage.Name))
 				return result, nil
 			}
-		}
 
-		if err != nil {
-			result.Status = "failed"
-			result.Message = fmt.Sprintf("%s 失败: %v", stage.Name, err)
-			return result, err
-		}
+			// 退回到前置阶段，目标阶段及其后续阶段重新执行
+			target, ok := graph.stages[stage.ReturnTo]
+			var err error
+			if !ok || !graph.isAncestor(stage.ReturnTo, stage.Key) {
+				err = fmt.Errorf("退回阶段 %s 无效", stage.ReturnTo)
+			} else if stage.MaxReturns > 0 && returns[stage.Key] >= stage.MaxReturns {
+				err = fmt.Errorf("超过最大退回次数 %d", stage.MaxReturns)
+			}
+			if err != nil {
+				exit := c.Exit
+				exit.Message = err.Error()
+				exitStage(ctx, state, req, stage, "failed", exit)
+				stopAll("failed", fmt.Sprintf("%s 失败: %v", stage.Name, err))
+				return result, err
+			}
 
-		logger.Info("阶段完成", zap.String("stage", stage.Name))
+			returns[stage.Key]++
+			exit := c.Exit
+			exit.Message = strings.TrimSpace(fmt.Sprintf("%s 退回到【%s】", exit.Message, target.Name))
+			exitStage(ctx, state, req, stage, "returned", exit)
+
+			reset := graph.descendants(target.Key)
+			for _, key := range reset {
+				delete(done, key)
+				if cancel, ok := running[key]; ok {
+					cancel()
+					cancelling[key] = true
+				}
+			}
+			state.resetStages(reset)
+
+			notify(ctx, stageNotification(req.Version, target, NotifyEventReturn, stageApprovers(target, req.Version),
+				fmt.Sprintf("版本 %s 在【%s】被退回到【%s】", req.Version.Name, stage.Name, target.Name),
+				map[string]string{"return_from": stage.Name, "comment": c.Exit.Message}))
+			logger.Info("阶段退回",
+				zap.String("stage", stage.Name),
+				zap.String("returnTo", target.Name),
+				zap.Int("returns", returns[stage.Key]))
+
+		default:
+			// 推进条目状态，生命周期不允许时流程失败
+			if err := advanceItems(ctx, req, stage, c.Exit); err != nil {
+				exit := c.Exit
+				exit.Message = err.Error()
+				exitStage(ctx, state, req, stage, "failed", exit)
+				stopAll("failed", fmt.Sprintf("%s 失败: %v", stage.Name, err))
+				return result, err
+			}
+			done[c.Key] = true
+			exitStage(ctx, state, req, stage, "completed", c.Exit)
+			logger.Info("阶段完成", zap.String("stage", stage.Name))
+		}
 	}
 
 	// 流程完成
+	state.Status = "completed"
 	result.CurrentStage = StageCompleted
 	result.Status = "completed"
 	result.Message = "升级流程完成"
 
-	// 执行知识沉淀
-	workflow.ExecuteActivity(ctx, ArchiveKnowledgeActivity, req.Version.ID).Get(ctx, nil)
-
-	logger.Info("升级流程完成", zap.String("version", req.Version.Name))
 	return result, nil
 }
 
+// stageCompletion 并行分支中阶段的执行结果
+type stageCompletion struct {
+	Key  string
+	Exit stageExit
+	Err  error
+}
+
+// runStage 根据阶段类型执行单个阶段
+// 测试不通过且未挂起时返回 Outcome 为 failed，由调用方决定退回或失败
+func runStage(ctx workflow.Context, state *WorkflowState, req *UpgradeWorkflowRequest, stage StageConfig) (stageExit, error) {
+	switch stage.Type {
+	case "approval":
+		if stage.AutoPass {
+			return waitForStageApprovalWithAutoPass(ctx, state, stage, req.Version)
+		}
+		return waitForStageApproval(ctx, state, stage, req.Version)
+	case "prepare":
+		return waitForStageApproval(ctx, state, stage, req.Version)
+	case "test":
+		exit := stageExit{Outcome: StageOutcomePassed, Operator: stageTester(stage.Key, req.Version)}
+		testResult, err := executeTestStage(ctx, state, *req, stage)
+		if errors.Is(err, errTestTimeout) {
+			exit.Outcome = StageOutcomeTimeout
+			return exit, err
+		} else if err != nil {
+			exit.Outcome = StageOutcomeFailed
+			return exit, err
+		}
+		if testResult.Passed {
+			return exit, nil
+		}
+
+		exit.Outcome = StageOutcomeFailed
+		exit.Message = testResult.Message
+		if stage.OnFail == FailPolicySuspend && len(testResult.PassedItems) > 0 {
+			// 挂起不通过条目，其余条目继续
+			req.Items, err = suspendFailedItems(ctx, *req, stage, testResult)
+			if err != nil {
+				return exit, err
+			}
+			exit.Outcome = StageOutcomePassed
+			exit.Message = fmt.Sprintf("挂起 %d 个不通过条目", len(testResult.FailedItems))
+		}
+		return exit, nil
+	}
+	return stageExit{}, fmt.Errorf("未知的阶段类型 %s", stage.Type)
+}
+
+// stageExit 阶段结束信息
+type stageExit struct {
+	Outcome  string
+	Operator string
+	Message  string
+}
+
+// persistContext 持久化 Activity 使用更长的重试，保证数据库与工作流一致
+func persistContext(ctx workflow.Context) workflow.Context {
+	return workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    10,
+		},
+	})
+}
+
+// enterStage 进入阶段：更新实时状态并持久化
+func enterStage(ctx workflow.Context, state *WorkflowState, req UpgradeWorkflowRequest, stage StageConfig) {
+	state.enterStage(ctx, stage, stageApprovers(stage, req.Version))
+
+	transition := StageTransition{
+		VersionID: req.Version.ID,
+		Stage:     stage.Key,
+		StageName: stage.Name,
+		Status:    "in_progress",
+		Iteration: state.Iterations[stage.Key],
+		Timestamp: workflow.Now(ctx),
+	}
+	if err := workflow.ExecuteActivity(persistContext(ctx), StartStageActivity, transition).Get(ctx, nil); err != nil {
+		logger.Error("阶段进入持久化失败", zap.String("stage", stage.Key), zap.Error(err))
+	}
+}
+
+// exitStage 结束阶段：更新实时状态并持久化，status 为 completed/failed/returned/cancelled
+func exitStage(ctx workflow.Context, state *WorkflowState, req UpgradeWorkflowRequest, stage StageConfig, status string, exit stageExit) {
+	state.finishStage(ctx, stage.Key, status, exit.Operator)
+
+	transition := StageTransition{
+		VersionID: req.Version.ID,
+		Stage:     stage.Key,
+		StageName: stage.Name,
+		Status:    status,
+		Outcome:   exit.Outcome,
+		Operator:  exit.Operator,
+		Message:   exit.Message,
+		Iteration: state.Iterations[stage.Key],
+		Timestamp: workflow.Now(ctx),
+	}
+	if err := workflow.ExecuteActivity(persistContext(ctx), FinishStageActivity, transition).Get(ctx, nil); err != nil {
+		logger.Error("阶段结束持久化失败", zap.String("stage", stage.Key), zap.Error(err))
+	}
+}
+
+// advanceItems 阶段完成后把版本中的条目推进到对应状态，见 stageItemStatus
+func advanceItems(ctx workflow.Context, req UpgradeWorkflowRequest, stage StageConfig, exit stageExit) error {
+	to, ok := stageItemStatus[stage.Key]
+	if !ok || len(req.Items) == 0 {
+		return nil
+	}
+	itemIDs := make([]string, 0, len(req.Items))
+	for _, item := range req.Items {
+		itemIDs = append(itemIDs, item.ID)
+	}
+	transition := ItemTransitionRequest{
+		VersionID: req.Version.ID,
+		Stage:     stage.Key,
+		ItemIDs:   itemIDs,
+		To:        to,
+		Operator:  exit.Operator,
+		Reason:    stage.Name + "完成",
+	}
+	if err := workflow.ExecuteActivity(persistContext(ctx), TransitionItemsActivity, transition).Get(ctx, nil); err != nil {
+		return err
+	}
+	logger.Info("条目状态已推进", zap.String("stage", stage.Key), zap.String("status", to), zap.Int("items", len(itemIDs)))
+	return nil
+}
+
+// stageApprovers 阶段负责人，即担任阶段可操作角色的人员；同一人担任多个角色时只出现一次
+func stageApprovers(stage StageConfig, version UpgradeVersion) []string {
+	var approvers []string
+	for _, role := range stageRoles(stage) {
+		if member := roleMember(role, version); member != "" && !containsKey(approvers, member) {
+			approvers = append(approvers, member)
+		}
+	}
+	return approvers
+}
+
+// stageTester 测试阶段负责人
+func stageTester(stage string, version UpgradeVersion) string {
+	switch stage {
+	case StageBTETest:
+		return version.BTETester
+	case StageGrayTest:
+		return version.GrayTester
+	case StageProdTest:
+		return version.ProdTester
+	}
+	return ""
+}
+
+// notify 发送通知，不等待结果；发送失败由重试策略重试
+func notify(ctx workflow.Context, n Notification) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    10 * time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    10 * time.Minute,
+			MaximumAttempts:    6,
+		},
+	})
+	workflow.ExecuteActivity(ctx, NotifyActivity, n)
+}
+
+// stageNotification 阶段通知，使用阶段配置的事件模板
+func stageNotification(version UpgradeVersion, stage StageConfig, event string, recipients []string, message string, vars map[string]string) Notification {
+	return Notification{
+		VersionID:  version.ID,
+		Stage:      stage.Key,
+		StageName:  stage.Name,
+		Event:      event,
+		Recipients: recipients,
+		Message:    message,
+		Template:   stage.Templates[event],
+		Vars:       vars,
+	}
+}
+
+// recordAudit 记录审计事件：挂到实时时间线并持久化
+func recordAudit(ctx workflow.Context, state *WorkflowState, event AuditEvent) {
+	event.VersionID = state.VersionID
+	event.OccurredAt = workflow.Now(ctx)
+	state.recordAudit(event)
+
+	if err := workflow.ExecuteActivity(persistContext(ctx), RecordAuditActivity, event).Get(ctx, nil); err != nil {
+		logger.Error("审计记录持久化失败",
+			zap.String("stage", event.Stage),
+			zap.String("eventType", event.EventType),
+			zap.Error(err))
+	}
+}
+
 // ============================================================
 // 阶段执行函数
 // ============================================================
 
+// defaultTestTimeout 测试阶段未配置超时时间时的默认等待时长
+const defaultTestTimeout = 4 * 24 * time.Hour
+
 // executeTestStage 测试阶段
-func executeTestStage(ctx workflow.Context, req UpgradeWorkflowRequest, stage string) (StageResult, error) {
+// 测试人员按条目逐个提交测试结果，所有条目都有结论后阶段结束；超时时间取阶段配置（紧急版本已压缩）
+func executeTestStage(ctx workflow.Context, state *WorkflowState, req UpgradeWorkflowRequest, stageConfig StageConfig) (StageResult, error) {
+	stage := stageConfig.Key
 	result := StageResult{Stage: stage, Passed: true}
 
-	// 等待测试完成的 Signal
-	testChan := workflow.GetSignalChannel(ctx, stage+"-test-complete")
+	// 待测试条目
+	pending := make(map[string]bool)
+	for _, item := range req.Items {
+		pending[item.ID] = true
+	}
+	state.setPendingItems(stage, pending)
+
+	// 等待逐条测试结果的 Signal
+	testChan := workflow.GetSignalChannel(ctx, stage+"-test-result")
+
+	timeoutCtx, cancelTimeout := workflow.WithCancel(ctx)
+	timeout := defaultTestTimeout
+	if stageConfig.Timeout > 0 {
+		timeout = time.Duration(stageConfig.Timeout) * time.Hour
+	}
+	timeoutTimer := newPausableTimer(timeoutCtx, state, timeout)
+	defer cancelTimeout()
 
-	selector := workflow.NewSelector(ctx)
-	timeoutTimer := workflow.NewTimer(ctx, 4*24*time.Hour)
+	for len(pending) > 0 {
+		selector := workflow.NewSelector(ctx)
+		var submission TestSubmission
+		var received bool
+		var timedOut bool
 
-	var testPassed bool
-	var received bool
-	selector.AddReceive(testChan, func(c workflow.ReceiveChannel, more bool) {
-		if more {
-			c.Receive(ctx, &testPassed)
-			received = true
+		selector.AddReceive(testChan, func(c workflow.ReceiveChannel, more bool) {
+			if more {
+				c.Receive(ctx, &submission)
+				received = true
+			}
+		})
+		selector.AddFuture(timeoutTimer, func(f workflow.Future) {
+			timedOut = true
+		})
+
+		selector.Select(ctx)
+
+		// 暂停期间收到的测试结果在恢复后处理
+		if received {
+			if err := awaitResume(ctx, state); err != nil {
+				return result, err
+			}
 		}
-	})
-	selector.AddFuture(timeoutTimer, func(f workflow.Future) {})
 
-	selector.Select(ctx)
+		// 分支被取消时计时器也会结束，不能当作超时
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		if timedOut {
+			recordAudit(ctx, state, AuditEvent{
+				Stage:     stage,
+				EventType: AuditTimeout,
+				Comment:   fmt.Sprintf("仍有 %d 个条目未提交结果", len(pending)),
+			})
+			return result, fmt.Errorf("%w，仍有 %d 个条目未提交结果", errTestTimeout, len(pending))
+		}
 
-	if !received {
-		return result, fmt.Errorf("测试超时")
+		if !received {
+			continue
+		}
+
+		if !pending[submission.ItemID] {
+			logger.Info("忽略非待测试条目的测试结果",
+				zap.String("stage", stage),
+				zap.String("itemId", submission.ItemID))
+			continue
+		}
+
+		submission.Stage = stage
+		if err := workflow.ExecuteActivity(ctx, RecordTestResultActivity, submission).Get(ctx, nil); err != nil {
+			return result, err
+		}
+
+		recordAudit(ctx, state, AuditEvent{
+			Stage:     stage,
+			EventType: AuditTest,
+			Operator:  submission.Tester,
+			ItemID:    submission.ItemID,
+			Passed:    submission.Passed,
+			Comment:   submission.BugDesc,
+		})
+
+		delete(pending, submission.ItemID)
+		state.setPendingItems(stage, pending)
+		if submission.Passed {
+			result.PassedItems = append(result.PassedItems, submission.ItemID)
+			state.recordTestResult(submission, TestResultPassed)
+		} else {
+			result.FailedItems = append(result.FailedItems, submission.ItemID)
+			if result.FailReasons == nil {
+				result.FailReasons = make(map[string]string)
+			}
+			result.FailReasons[submission.ItemID] = submission.BugDesc
+			state.recordTestResult(submission, TestResultFailed)
+		}
+
+		logger.Info("条目测试结果已记录",
+			zap.String("stage", stage),
+			zap.String("itemId", submission.ItemID),
+			zap.String("tester", submission.Tester),
+			zap.Bool("passed", submission.Passed),
+			zap.Int("pending", len(pending)))
 	}
 
-	result.Passed = testPassed
-	if !testPassed {
-		result.Message = "测试存在不通过条目"
+	if len(result.FailedItems) > 0 {
+		result.Passed = false
+		result.Message = fmt.Sprintf("测试存在 %d 个不通过条目", len(result.FailedItems))
 	}
 
 	return result, nil
 }
 
+// suspendFailedItems 挂起测试不通过的条目，返回剩余继续升级的条目
+func suspendFailedItems(ctx workflow.Context, req UpgradeWorkflowRequest, stage StageConfig, testResult StageResult) ([]UpgradeItem, error) {
+	reasons := make(map[string]string, len(testResult.FailedItems))
+	for _, itemID := range testResult.FailedItems {
+		reason := fmt.Sprintf("%s 未通过", stage.Name)
+		if bugDesc := testResult.FailReasons[itemID]; bugDesc != "" {
+			reason += ": " + bugDesc
+		}
+		reasons[itemID] = reason
+	}
+
+	suspendReq := SuspendItemsRequest{
+		VersionID: req.Version.ID,
+		Stage:     stage.Key,
+		Reasons:   reasons,
+	}
+	if err := workflow.ExecuteActivity(ctx, SuspendItemsActivity, suspendReq).Get(ctx, nil); err != nil {
+		return req.Items, err
+	}
+
+	var remaining []UpgradeItem
+	for _, item := range req.Items {
+		if _, ok := reasons[item.ID]; !ok {
+			remaining = append(remaining, item)
+		}
+	}
+
+	notify(ctx, stageNotification(req.Version, stage, NotifyEventSuspend, []string{req.Version.VersionOwner},
+		fmt.Sprintf("版本 %s 在【%s】挂起 %d 个条目，请版本负责人 %s 确认",
+			req.Version.Name, stage.Name, len(reasons), req.Version.VersionOwner),
+		map[string]string{"count": strconv.Itoa(len(reasons))}))
+
+	logger.Info("不通过条目已挂起",
+		zap.String("stage", stage.Name),
+		zap.Strings("suspended", testResult.FailedItems),
+		zap.Int("remaining", len(remaining)))
+	return remaining, nil
+}
+
 // ============================================================
 // 等待审批辅助函数
 // ============================================================
 
 // waitForStageApproval 等待阶段审批
-// 驳回后会继续等待重新审批，直到通过或超时
-func waitForStageApproval(ctx workflow.Context, stage string, timeout time.Duration) error {
+// 按会签方式收集审批人表态，直到通过或超时；会签被驳回后重新会签，配置了退回阶段时驳回直接返回
+func waitForStageApproval(ctx workflow.Context, state *WorkflowState, stageConfig StageConfig, version UpgradeVersion) (stageExit, error) {
+	stage := stageConfig.Key
 	approvalChan := workflow.GetSignalChannel(ctx, stage+"-approval")
+	cs := newCountersign(stageConfig, stageApprovers(stageConfig, version))
 
-	// 创建超时计时器
-	timeoutCtx, cancelTimeout := workflow.WithCancel(ctx)
-	timeoutFuture := workflow.NewTimer(timeoutCtx, timeout)
+	// 创建超时计时器，并按配置在超时前提醒未表态的审批人
+	deadline := newStageDeadline(ctx, state, stageConfig, version, cs.pending)
+	defer deadline.stop()
+	deadline.start(time.Duration(stageConfig.Timeout) * time.Hour)
 
 	for {
 		selector := workflow.NewSelector(ctx)
@@ -168,63 +642,166 @@ func waitForStageApproval(ctx workflow.Context, stage string, timeout time.Durat
 		})
 
 		// 监听超时
-		selector.AddFuture(timeoutFuture, func(f workflow.Future) {
+		selector.AddFuture(deadline.timer, func(f workflow.Future) {
 			timedOut = true
 		})
 
 		selector.Select(ctx)
 
-		if timedOut {
-			return fmt.Errorf("阶段 %s 审批超时", stage)
+		// 暂停期间收到的审批在恢复后处理
+		if received {
+			if err := awaitResume(ctx, state); err != nil {
+				return stageExit{}, err
+			}
 		}
 
-		if received {
-			if action.Approved {
-				// 审批通过，取消超时计时器，继续流程
-				cancelTimeout()
-				logger.Info("审批通过", zap.String("stage", stage), zap.String("operator", action.Operator))
-				return nil
-			} else {
-				// 驳回，记录日志，继续等待重新审批
-				logger.Info("审批驳回，等待重新提交",
-					zap.String("stage", stage),
-					zap.String("operator", action.Operator),
-					zap.String("comment", action.Comment))
-				// 继续循环等待下一次审批
+		if ctx.Err() != nil {
+			return stageExit{}, ctx.Err()
+		}
+
+		if timedOut {
+			// 配置了升级策略时，首次超时升级到版本负责人并延长截止时间
+			if canEscalate(stageConfig, version, deadline) {
+				if err := escalateStage(ctx, state, stageConfig, version, cs, deadline); err != nil {
+					return stageExit{Outcome: StageOutcomeTimeout}, err
+				}
+				continue
 			}
+			recordAudit(ctx, state, AuditEvent{Stage: stage, EventType: AuditTimeout, Comment: cs.summary()})
+			return stageExit{Outcome: StageOutcomeTimeout}, fmt.Errorf("阶段 %s 审批超时", stage)
 		}
+
+		if !received || !recordSignature(ctx, state, stage, cs, action) {
+			continue
+		}
+
+		if cs.approved() {
+			// 审批通过，继续流程
+			logger.Info("审批通过", zap.String("stage", stage), zap.String("operator", cs.approvedBy()))
+			return approvedExit(cs, action), nil
+		}
+		if !cs.rejected() {
+			logger.Info("会签进行中",
+				zap.String("stage", stage),
+				zap.String("operator", action.Operator),
+				zap.Strings("pending", cs.pending()))
+			continue
+		}
+
+		if stageConfig.ReturnTo != "" {
+			logger.Info("审批驳回，退回到指定阶段",
+				zap.String("stage", stage),
+				zap.String("returnTo", stageConfig.ReturnTo),
+				zap.String("operator", action.Operator))
+			return stageExit{Outcome: StageOutcomeRejected, Operator: action.Operator, Message: action.Comment}, nil
+		}
+
+		// 驳回，记录日志，重新会签
+		logger.Info("审批驳回，等待重新提交",
+			zap.String("stage", stage),
+			zap.String("operator", action.Operator),
+			zap.String("comment", action.Comment))
+		cs.reset()
+		state.updateCountersign(stage, cs)
 	}
 }
 
 // waitForStageApprovalWithAutoPass 等待阶段审批（超时自动通过）
-func waitForStageApprovalWithAutoPass(ctx workflow.Context, stage string, timeout time.Duration) error {
+// 配置了退回阶段时驳回不会使流程失败
+func waitForStageApprovalWithAutoPass(ctx workflow.Context, state *WorkflowState, stageConfig StageConfig, version UpgradeVersion) (stageExit, error) {
+	stage := stageConfig.Key
 	approvalChan := workflow.GetSignalChannel(ctx, stage+"-approval")
+	cs := newCountersign(stageConfig, stageApprovers(stageConfig, version))
 
-	selector := workflow.NewSelector(ctx)
-	timeoutTimer := workflow.NewTimer(ctx, timeout)
+	deadline := newStageDeadline(ctx, state, stageConfig, version, cs.pending)
+	defer deadline.stop()
+	deadline.start(time.Duration(stageConfig.Timeout) * time.Hour)
 
-	var action ApprovalAction
-	var received bool
-	selector.AddReceive(approvalChan, func(c workflow.ReceiveChannel, more bool) {
-		if more {
-			c.Receive(ctx, &action)
-			received = true
+	for {
+		selector := workflow.NewSelector(ctx)
+		var action ApprovalAction
+		var received bool
+		selector.AddReceive(approvalChan, func(c workflow.ReceiveChannel, more bool) {
+			if more {
+				c.Receive(ctx, &action)
+				received = true
+			}
+		})
+		selector.AddFuture(deadline.timer, func(f workflow.Future) {})
+
+		selector.Select(ctx)
+
+		// 暂停期间收到的审批在恢复后处理
+		if received {
+			if err := awaitResume(ctx, state); err != nil {
+				return stageExit{}, err
+			}
 		}
-	})
-	selector.AddFuture(timeoutTimer, func(f workflow.Future) {})
 
-	selector.Select(ctx)
+		if ctx.Err() != nil {
+			return stageExit{}, ctx.Err()
+		}
 
-	if timeoutTimer.IsReady() && !received {
-		logger.Info("超时自动通过", zap.String("stage", stage))
-		return nil
+		if deadline.timer.IsReady() && !received {
+			logger.Info("超时自动通过", zap.String("stage", stage))
+			recordAudit(ctx, state, AuditEvent{Stage: stage, EventType: AuditAutoPass, Passed: true, Comment: cs.summary()})
+			return stageExit{Outcome: StageOutcomeAutoPass, Message: "超时自动通过"}, nil
+		}
+
+		if !recordSignature(ctx, state, stage, cs, action) {
+			continue
+		}
+
+		if cs.approved() {
+			return approvedExit(cs, action), nil
+		}
+		if cs.rejected() {
+			exit := stageExit{Outcome: StageOutcomeRejected, Operator: action.Operator, Message: action.Comment}
+			if stageConfig.ReturnTo != "" {
+				return exit, nil
+			}
+			return exit, fmt.Errorf("阶段 %s 审批未通过: %s", stage, action.Comment)
+		}
 	}
+}
 
-	if received && !action.Approved {
-		return fmt.Errorf("阶段 %s 审批未通过: %s", stage, action.Comment)
+// recordSignature 记录审批人表态，非本阶段审批人的操作被忽略
+func recordSignature(ctx workflow.Context, state *WorkflowState, stage string, cs *countersign, action ApprovalAction) bool {
+	if !cs.record(action) {
+		logger.Info("忽略非审批人的审批操作",
+			zap.String("stage", stage),
+			zap.String("operator", action.Operator))
+		return false
 	}
+	state.recordApproval(action)
+	recordAudit(ctx, state, approvalAudit(stage, action))
+	state.updateCountersign(stage, cs)
+	return true
+}
 
-	return nil
+// approvedExit 会签通过的阶段结束信息，多人会签时操作人为所有通过的审批人，升级后为升级对象
+func approvedExit(cs *countersign, last ApprovalAction) stageExit {
+	exit := stageExit{Outcome: StageOutcomeApproved, Operator: last.Operator, Message: last.Comment}
+	if cs.required > 1 && cs.override == nil {
+		exit.Operator = cs.approvedBy()
+		exit.Message = cs.summary()
+	}
+	return exit
+}
+
+// approvalAudit 审批动作对应的审计事件
+func approvalAudit(stage string, action ApprovalAction) AuditEvent {
+	eventType := AuditReject
+	if action.Approved {
+		eventType = AuditApprove
+	}
+	return AuditEvent{
+		Stage:     stage,
+		EventType: eventType,
+		Operator:  action.Operator,
+		Passed:    action.Approved,
+		Comment:   action.Comment,
+	}
 }
 
 // ============================================================
@@ -233,56 +810,185 @@ func waitForStageApprovalWithAutoPass(ctx workflow.Context, stage string, timeou
 
 // GetFlowConfigActivity 获取流程配置 Activity
 func GetFlowConfigActivity(ctx context.Context, flowConfigID uint) ([]StageConfig, error) {
-	config, err := GetFlowConfig(flowConfigID)
+	return LoadFlowStages(flowConfigID)
+}
+
+// GetFlowRevisionActivity 获取流程配置修订的阶段 Activity
+func GetFlowRevisionActivity(ctx context.Context, revisionID uint) ([]StageConfig, error) {
+	rev, err := store.GetFlowRevisionByID(revisionID)
 	if err != nil {
-		// 返回默认配置
-		return []StageConfig{
-			{Key: StageBTEConfirm, Name: "BTE条目确认", Type: "approval", Enabled: true, Timeout: 72, Order: 1},
-			{Key: StageBTEFinalize, Name: "BTE定版", Type: "approval", Enabled: true, Timeout: 48, Order: 2},
-			{Key: StageBTEPrepare, Name: "BTE版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 3},
-			{Key: StageBTETest, Name: "BTE测试", Type: "test", Enabled: true, Timeout: 96, Order: 4},
-			{Key: StageProdFinalize, Name: "生产定版", Type: "approval", Enabled: true, Timeout: 48, Order: 5},
-			{Key: StageProdPrepare, Name: "生产版本准备", Type: "prepare", Enabled: true, Timeout: 24, Order: 6},
-			{Key: StageProdTest, Name: "生产测试", Type: "test", Enabled: true, Timeout: 96, Order: 7},
-			{Key: StageCloseConfirm, Name: "关闭确认", Type: "approval", Enabled: true, Timeout: 72, AutoPass: true, Order: 8},
-			{Key: StageEndConfirm, Name: "结束确认", Type: "approval", Enabled: true, Timeout: 48, AutoPass: true, Order: 9},
-		}, nil
-	}
-	return GetFlowStages(config)
+		return nil, err
+	}
+	return GetRevisionStages(rev)
 }
 
-// NotifyActivity 通知 Activity
-func NotifyActivity(ctx context.Context, message string) error {
-	logger.Info("发送通知", zap.String("message", message))
-	// 实际项目中：发送钉钉、邮件、短信等
+// RecordTestResultActivity 记录条目测试结果 Activity
+func RecordTestResultActivity(ctx context.Context, submission TestSubmission) error {
+	testResult := TestResultFailed
+	if submission.Passed {
+		testResult = TestResultPassed
+	}
+	return store.UpdateItemTestResult(submission.ItemID, submission.Stage, testResult)
+}
+
+// SuspendItemsActivity 挂起条目 Activity
+func SuspendItemsActivity(ctx context.Context, req SuspendItemsRequest) error {
+	return store.SuspendVersionItems(req.VersionID, req.Stage, req.Reasons)
+}
+
+// StartStageActivity 记录进入阶段 Activity
+func StartStageActivity(ctx context.Context, transition StageTransition) error {
+	return store.StartStage(transition)
+}
+
+// FinishStageActivity 记录阶段结束 Activity
+func FinishStageActivity(ctx context.Context, transition StageTransition) error {
+	return store.FinishStage(transition)
+}
+
+// FinishVersionActivity 记录版本最终结果 Activity
+func FinishVersionActivity(ctx context.Context, req FinishVersionRequest) error {
+	return store.FinishVersion(req.Result, req.CompletedAt)
+}
+
+// RecordAuditActivity 写入审计记录 Activity
+func RecordAuditActivity(ctx context.Context, event AuditEvent) error {
+	return store.CreateAudit(event)
+}
+
+// EscalateStageActivity 确认升级审计已保存 Activity
+// 升级对象的审批权限在校验时从审计记录读取（见 rbac.go escalatedTo），审计未保存时返回错误重试
+func EscalateStageActivity(ctx context.Context, req EscalateStageRequest) error {
+	ok, err := escalatedTo(req.VersionID, req.Stage, req.To)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("版本 %s 阶段 %s 升级到 %s 的审计记录未保存", req.VersionID, req.Stage, req.To)
+	}
 	return nil
 }
 
-// ArchiveKnowledgeActivity 知识沉淀 Activity
-func ArchiveKnowledgeActivity(ctx context.Context, versionID string) error {
-	logger.Info("知识沉淀完成", zap.String("versionId", versionID))
-	// 实际项目中：将需求、设计、测试用例等归档
+// NotifyActivity 通知 Activity
+// 按接收人的渠道偏好分组发送；部分渠道失败时返回错误触发重试，已成功的渠道记录在心跳中不再重复发送
+func NotifyActivity(ctx context.Context, n Notification) error {
+	title, content := renderNotification(n)
+	channels, groups := groupRecipients(n.Recipients)
+
+	var delivered []string
+	if activity.HasHeartbeatDetails(ctx) {
+		activity.GetHeartbeatDetails(ctx, &delivered)
+	}
+
+	var failed []string
+	for _, channel := range channels {
+		if containsKey(delivered, channel) {
+			continue
+		}
+		notifier, ok := getNotifier(channel)
+		if !ok {
+			logger.Warn("通知渠道未配置", zap.String("channel", channel))
+			continue
+		}
+		msg := Message{Title: title, Content: content, Recipients: groups[channel]}
+		if err := notifier.Send(ctx, msg); err != nil {
+			logger.Error("通知发送失败", zap.String("channel", channel), zap.Error(err))
+			failed = append(failed, fmt.Sprintf("%s: %v", channel, err))
+			continue
+		}
+		delivered = append(delivered, channel)
+		activity.RecordHeartbeat(ctx, delivered)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("通知发送失败: %s", strings.Join(failed, "; "))
+	}
 	return nil
 }
 
+// groupRecipients 按渠道偏好分组接收人，未设置偏好的使用默认渠道；返回渠道的首次出现顺序
+func groupRecipients(users []string) ([]string, map[string][]Recipient) {
+	var channels []string
+	groups := make(map[string][]Recipient)
+	add := func(channel string, recipient *Recipient) {
+		if _, ok := groups[channel]; !ok {
+			channels = append(channels, channel)
+			groups[channel] = nil
+		}
+		if recipient != nil {
+			groups[channel] = append(groups[channel], *recipient)
+		}
+	}
+
+	if len(users) == 0 {
+		for _, channel := range defaultChannels {
+			add(channel, nil)
+		}
+		return channels, groups
+	}
+
+	for _, user := range users {
+		recipient := Recipient{User: user}
+		userChannels := defaultChannels
+		if pref, err := store.GetNotifyPreference(user); err == nil {
+			recipient.Email = pref.Email
+			recipient.Mobile = pref.Mobile
+			if list := pref.ChannelList(); len(list) > 0 {
+				userChannels = list
+			}
+		}
+		for _, channel := range userChannels {
+			add(channel, &recipient)
+		}
+	}
+	return channels, groups
+}
+
+// TransitionItemsActivity 变更条目状态 Activity，生命周期不允许的变更不重试
+func TransitionItemsActivity(ctx context.Context, req ItemTransitionRequest) error {
+	err := store.TransitionItems(req)
+	var illegal *IllegalTransitionError
+	if errors.As(err, &illegal) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "IllegalTransition", err)
+	}
+	return err
+}
+
+// UpdateVersionStatusActivity 更新版本运行状态 Activity
+func UpdateVersionStatusActivity(ctx context.Context, req VersionStatusUpdate) error {
+	return store.UpdateVersionStatus(req.VersionID, req.Status)
+}
+
+// RestoreItemsActivity 取消版本时恢复条目状态 Activity
+func RestoreItemsActivity(ctx context.Context, req RestoreItemsRequest) error {
+	return store.RestoreVersionItems(req.VersionID, req.Items)
+}
+
 // ============================================================
 // Worker 启动
 // ============================================================
 
-func StartWorker(c client.Client) {
-	w := worker.New(c, TaskQueue, worker.Options{})
+// newWorker 创建 Worker 并注册 Workflow 和 Activities，停止时最多等待 stopTimeout 让进行中的 Activity 完成
+func newWorker(c client.Client, stopTimeout time.Duration) worker.Worker {
+	w := worker.New(c, TaskQueue, worker.Options{WorkerStopTimeout: stopTimeout})
 
 	// 注册 Workflow
 	w.RegisterWorkflow(UpgradeWorkflow)
 
 	// 注册 Activities
 	w.RegisterActivity(GetFlowConfigActivity)
+	w.RegisterActivity(GetFlowRevisionActivity)
+	w.RegisterActivity(RecordTestResultActivity)
+	w.RegisterActivity(SuspendItemsActivity)
+	w.RegisterActivity(StartStageActivity)
+	w.RegisterActivity(FinishStageActivity)
+	w.RegisterActivity(FinishVersionActivity)
+	w.RegisterActivity(RecordAuditActivity)
 	w.RegisterActivity(NotifyActivity)
+	w.RegisterActivity(EscalateStageActivity)
+	w.RegisterActivity(UpdateVersionStatusActivity)
+	w.RegisterActivity(RestoreItemsActivity)
+	w.RegisterActivity(TransitionItemsActivity)
 	w.RegisterActivity(ArchiveKnowledgeActivity)
-
-	logger.Info("Worker 启动中...")
-	err := w.Run(worker.InterruptCh())
-	if err != nil {
-		logger.Fatal("Worker 启动失败", zap.Error(err))
-	}
+	return w
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 工作流测试使用流程模拟（simulate.go）运行 UpgradeWorkflow，Activity 为内存实现
+
+var testVersion = UpgradeVersion{ID: "V-WF", Name: "测试版本", VersionOwner: "owner", VendorOwner: "vendor"}
+
+func approvalStage(key string, deps ...string) StageConfig {
+	return StageConfig{Key: key, Name: "阶段" + key, Type: "approval", Enabled: true, Timeout: 24, DependsOn: deps}
+}
+
+func approve(at, stage, operator string) SimulationStep {
+	return SimulationStep{At: at, Action: SimulateApprove, Stage: stage, Operator: operator}
+}
+
+func reject(at, stage string) SimulationStep {
+	return SimulationStep{At: at, Action: SimulateReject, Stage: stage, Operator: "owner", Comment: "驳回"}
+}
+
+func runSimulation(t *testing.T, version UpgradeVersion, stages []StageConfig, steps ...SimulationStep) *SimulateResult {
+	t.Helper()
+	logger = zap.NewNop()
+	scheduled, err := parseSimulationSteps(steps, stages)
+	if err != nil {
+		t.Fatalf("模拟脚本无效: %v", err)
+	}
+	result, err := SimulateFlow(stages, version, nil, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// stageRun 阶段的一次执行，时间相对流程开始
+type stageRun struct {
+	started  time.Duration
+	finished time.Duration
+	status   string
+}
+
+// stageRuns 阶段每次执行的开始、结束时间和结束状态
+func stageRuns(t *testing.T, result *SimulateResult, key string) []stageRun {
+	t.Helper()
+	if len(result.Transitions) == 0 {
+		t.Fatal("没有阶段记录")
+	}
+	start := result.Transitions[0].Timestamp
+	var runs []stageRun
+	for _, tr := range result.Transitions {
+		if tr.Stage != key {
+			continue
+		}
+		if tr.Status == "in_progress" {
+			runs = append(runs, stageRun{started: tr.Timestamp.Sub(start)})
+			continue
+		}
+		if len(runs) == 0 || runs[len(runs)-1].status != "" {
+			t.Fatalf("阶段 %s 结束记录没有对应的开始记录", key)
+		}
+		runs[len(runs)-1].finished = tr.Timestamp.Sub(start)
+		runs[len(runs)-1].status = tr.Status
+	}
+	return runs
+}
+
+func assertStatus(t *testing.T, result *SimulateResult, status string) {
+	t.Helper()
+	if result.Status != status {
+		t.Fatalf("流程状态 %s（%s），期望 %s", result.Status, result.Message, status)
+	}
+}
+
+// TestParallelFanOutAndJoin b、c 在 a 完成后同时开始，d 在 b、c 都完成后开始
+func TestParallelFanOutAndJoin(t *testing.T) {
+	stages := []StageConfig{approvalStage("a"), approvalStage("b", "a"), approvalStage("c", "a"), approvalStage("d", "b", "c")}
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		approve("2h", "c", "owner"),
+		approve("3h", "b", "owner"),
+		approve("4h", "d", "owner"),
+	)
+	assertStatus(t, result, "completed")
+
+	b, c, d := stageRuns(t, result, "b"), stageRuns(t, result, "c"), stageRuns(t, result, "d")
+	if len(b) != 1 || len(c) != 1 || len(d) != 1 {
+		t.Fatalf("每个阶段应执行一次: b=%v c=%v d=%v", b, c, d)
+	}
+	if b[0].started != time.Hour || c[0].started != time.Hour {
+		t.Fatalf("b、c 应在 a 完成时同时开始: b=%v c=%v", b[0].started, c[0].started)
+	}
+	if c[0].finished != 2*time.Hour || d[0].started != 3*time.Hour {
+		t.Fatalf("d 应等 b、c 都完成后开始: c 结束 %v，d 开始 %v", c[0].finished, d[0].started)
+	}
+}
+
+// TestReturnResetsDescendants c 退回到 a 时取消进行中的 b，a 及其后续阶段重新执行
+func TestReturnResetsDescendants(t *testing.T) {
+	c := approvalStage("c", "a")
+	c.ReturnTo = "a"
+	stages := []StageConfig{approvalStage("a"), approvalStage("b", "a"), c}
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		reject("2h", "c"),
+		approve("3h", "a", "owner"),
+		approve("4h", "b", "owner"),
+		approve("5h", "c", "owner"),
+	)
+	assertStatus(t, result, "completed")
+
+	a, b, cr := stageRuns(t, result, "a"), stageRuns(t, result, "b"), stageRuns(t, result, "c")
+	if len(a) != 2 || len(b) != 2 || len(cr) != 2 {
+		t.Fatalf("退回后 a、b、c 应各执行两次: a=%v b=%v c=%v", a, b, cr)
+	}
+	if cr[0].status != "returned" || b[0].status != "cancelled" {
+		t.Fatalf("第一次执行: c %s，b %s；期望 returned、cancelled", cr[0].status, b[0].status)
+	}
+	if a[1].started != 2*time.Hour || b[1].started != 3*time.Hour {
+		t.Fatalf("退回后 a 应立即重新开始、b 在 a 完成后开始: a=%v b=%v", a[1].started, b[1].started)
+	}
+}
+
+// TestMaxReturnsExceeded 退回次数达到上限后再次驳回，流程失败
+func TestMaxReturnsExceeded(t *testing.T) {
+	b := approvalStage("b", "a")
+	b.ReturnTo = "a"
+	b.MaxReturns = 1
+	stages := []StageConfig{approvalStage("a"), b}
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		reject("2h", "b"),
+		approve("3h", "a", "owner"),
+		reject("4h", "b"),
+	)
+	assertStatus(t, result, "failed")
+	if !strings.Contains(result.Message, "超过最大退回次数 1") {
+		t.Fatalf("失败原因: %s", result.Message)
+	}
+	if runs := stageRuns(t, result, "b"); len(runs) != 2 || runs[0].status != "returned" || runs[1].status != "failed" {
+		t.Fatalf("b 的执行记录: %v", runs)
+	}
+}
+
+// TestCancelDuringParallelBranches 取消时结束所有进行中的分支
+func TestCancelDuringParallelBranches(t *testing.T) {
+	stages := []StageConfig{approvalStage("a"), approvalStage("b", "a"), approvalStage("c", "a"), approvalStage("d", "b", "c")}
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		approve("2h", "b", "owner"),
+		SimulationStep{At: "3h", Action: SimulateCancel, Operator: "owner", Comment: "需求变更"},
+	)
+	assertStatus(t, result, "cancelled")
+
+	if runs := stageRuns(t, result, "c"); len(runs) != 1 || runs[0].status != "cancelled" || runs[0].finished != 3*time.Hour {
+		t.Fatalf("进行中的 c 应在取消时结束: %v", runs)
+	}
+	if runs := stageRuns(t, result, "b"); len(runs) != 1 || runs[0].status != "completed" {
+		t.Fatalf("已完成的 b 不受取消影响: %v", runs)
+	}
+	if runs := stageRuns(t, result, "d"); len(runs) != 0 {
+		t.Fatalf("d 不应开始: %v", runs)
+	}
+}
+
+// TestPauseDuringParallelBranches 暂停期间并行分支不超时，收到的审批在恢复后处理
+func TestPauseDuringParallelBranches(t *testing.T) {
+	b, c := approvalStage("b", "a"), approvalStage("c", "a")
+	b.Timeout, c.Timeout = 2, 2
+	stages := []StageConfig{approvalStage("a"), b, c}
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		SimulationStep{At: "90m", Action: SimulatePause, Operator: "owner"},
+		approve("2h", "b", "owner"),
+		approve("4h", "c", "owner"),
+		SimulationStep{At: "5h", Action: SimulateResume, Operator: "owner"},
+	)
+	assertStatus(t, result, "completed")
+
+	for _, key := range []string{"b", "c"} {
+		runs := stageRuns(t, result, key)
+		if len(runs) != 1 || runs[0].status != "completed" || runs[0].finished != 5*time.Hour {
+			t.Fatalf("%s 应在恢复后完成: %v", key, runs)
+		}
+	}
+}
+
+// TestCountersignAllApprovers all 方式需要所有审批人通过；同一人担任两个角色时只需表态一次
+func TestCountersignAllApprovers(t *testing.T) {
+	a := approvalStage("a")
+	a.Roles = []string{RoleVersionOwner, RoleVendorOwner}
+	a.ApprovalMode = ApprovalModeAll
+	stages := []StageConfig{a}
+
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"),
+		approve("2h", "a", "vendor"),
+	)
+	assertStatus(t, result, "completed")
+	if runs := stageRuns(t, result, "a"); len(runs) != 1 || runs[0].finished != 2*time.Hour {
+		t.Fatalf("a 应在两人都通过后完成: %v", runs)
+	}
+
+	same := testVersion
+	same.VendorOwner = same.VersionOwner
+	result = runSimulation(t, same, stages, approve("1h", "a", "owner"))
+	assertStatus(t, result, "completed")
+	if runs := stageRuns(t, result, "a"); len(runs) != 1 || runs[0].finished != time.Hour {
+		t.Fatalf("同一人担任两个角色时 a 应在其通过后完成: %v", runs)
+	}
+}
+
+// TestEscalationToVersionOwner 审批超时升级到版本负责人，由其表态决定阶段结果
+func TestEscalationToVersionOwner(t *testing.T) {
+	a := approvalStage("a")
+	a.Roles = []string{RoleVendorOwner}
+	a.Timeout = 2
+	a.Escalation = EscalationEscalate
+	a.ExtendHours = 2
+	stages := []StageConfig{a}
+
+	result := runSimulation(t, testVersion, stages,
+		approve("1h", "a", "owner"), // 升级前版本负责人不是审批人
+		approve("3h", "a", "owner"),
+	)
+	assertStatus(t, result, "completed")
+	if runs := stageRuns(t, result, "a"); len(runs) != 1 || runs[0].finished != 3*time.Hour {
+		t.Fatalf("a 应在升级后版本负责人通过时完成: %v", runs)
+	}
+	escalated := false
+	for _, audit := range result.Audits {
+		if audit.EventType == AuditEscalate && audit.Stage == "a" && audit.Operator == "owner" {
+			escalated = true
+		}
+	}
+	if !escalated {
+		t.Fatalf("缺少升级审计记录: %+v", result.Audits)
+	}
+
+	result = runSimulation(t, testVersion, stages)
+	assertStatus(t, result, "failed")
+}
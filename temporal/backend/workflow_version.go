@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	filterpb "go.temporal.io/api/filter/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.uber.org/zap"
+)
+
+// ============================================================
+// 工作流代码版本
+// Temporal 重放历史时要求命令（Activity、计时器、Signal 等待等）的顺序与代码一致，
+// 改变命令顺序的修改会让运行中的流程在新 Worker 上重放失败（non-determinism）：
+//   需要与运行中的流程兼容的修改，使用 workflow.GetVersion 保留旧的执行路径
+//   其他修改增加 workflowCodeVersion，部署前排空运行中的流程
+// 流程启动时在 Memo 中记录 workflowCodeVersion（之前启动的流程没有记录，视为版本 0）
+// worker 和 all 启动时检查运行中的 UpgradeWorkflow，有其他版本启动的流程时拒绝启动
+// 排空步骤：
+//   1. 停止创建新版本（停止 serve 或暂停入口）
+//   2. 旧 Worker 继续运行，等待运行中的版本结束，或在页面上取消
+//   3. 停止旧 Worker，部署新的 worker 和 serve
+// 确认运行中的流程不受影响时，可以用 -skip-drain-check 跳过检查
+// ============================================================
+
+// workflowCodeVersion 工作流代码版本，改变命令顺序且没有使用 workflow.GetVersion 时加 1
+const workflowCodeVersion = 1
+
+// workflowCodeVersionMemo 记录工作流代码版本的 Memo 字段
+const workflowCodeVersionMemo = "code_version"
+
+// upgradeWorkflowMemo 启动流程时附带的 Memo
+func upgradeWorkflowMemo() map[string]interface{} {
+	return map[string]interface{}{workflowCodeVersionMemo: workflowCodeVersion}
+}
+
+// checkWorkflowsDrained 检查运行中的流程是否都由当前代码版本启动
+func checkWorkflowsDrained(ctx context.Context, c client.Client, namespace string) error {
+	var incompatible []string
+	count := 0
+	req := &workflowservice.ListOpenWorkflowExecutionsRequest{
+		Namespace:       namespace,
+		MaximumPageSize: 100,
+		Filters: &workflowservice.ListOpenWorkflowExecutionsRequest_TypeFilter{
+			TypeFilter: &filterpb.WorkflowTypeFilter{Name: "UpgradeWorkflow"},
+		},
+	}
+	for {
+		resp, err := c.ListOpenWorkflow(ctx, req)
+		if err != nil {
+			return fmt.Errorf("查询运行中的流程失败: %w", err)
+		}
+		for _, execution := range resp.GetExecutions() {
+			version := 0
+			if payload, ok := execution.GetMemo().GetFields()[workflowCodeVersionMemo]; ok {
+				if err := converter.GetDefaultDataConverter().FromPayload(payload, &version); err != nil {
+					version = 0
+				}
+			}
+			if version != workflowCodeVersion {
+				count++
+				if len(incompatible) < 10 {
+					incompatible = append(incompatible, fmt.Sprintf("%s(v%d)", execution.GetExecution().GetWorkflowId(), version))
+				}
+			}
+		}
+		if len(resp.GetNextPageToken()) == 0 {
+			break
+		}
+		req.NextPageToken = resp.GetNextPageToken()
+	}
+
+	if count > 0 {
+		return fmt.Errorf("有 %d 个运行中的流程由其他代码版本启动（当前 v%d）: %s；请先用旧 Worker 排空或取消这些流程，确认兼容时使用 -skip-drain-check",
+			count, workflowCodeVersion, strings.Join(incompatible, ", "))
+	}
+	logger.Info("运行中的流程与当前代码版本一致", zap.Int("codeVersion", workflowCodeVersion))
+	return nil
+}
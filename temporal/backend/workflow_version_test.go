@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	commonpb "go.temporal.io/api/common/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+)
+
+// openExecution 运行中的流程，version 为 0 时没有记录代码版本
+func openExecution(t *testing.T, workflowID string, version int) *workflowpb.WorkflowExecutionInfo {
+	t.Helper()
+	info := &workflowpb.WorkflowExecutionInfo{Execution: &commonpb.WorkflowExecution{WorkflowId: workflowID}}
+	if version > 0 {
+		payload, err := converter.GetDefaultDataConverter().ToPayload(version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.Memo = &commonpb.Memo{Fields: map[string]*commonpb.Payload{workflowCodeVersionMemo: payload}}
+	}
+	return info
+}
+
+func TestCheckWorkflowsDrained(t *testing.T) {
+	logger = zap.NewNop()
+
+	c := &mocks.Client{}
+	c.On("ListOpenWorkflow", mock.Anything, mock.Anything).Return(&workflowservice.ListOpenWorkflowExecutionsResponse{
+		Executions: []*workflowpb.WorkflowExecutionInfo{openExecution(t, "upgrade-V1", workflowCodeVersion)},
+	}, nil).Once()
+	if err := checkWorkflowsDrained(context.Background(), c, "default"); err != nil {
+		t.Fatalf("当前版本启动的流程不应阻止启动: %v", err)
+	}
+
+	// 第二页中有旧版本和没有记录版本的流程
+	c = &mocks.Client{}
+	c.On("ListOpenWorkflow", mock.Anything, mock.MatchedBy(func(req *workflowservice.ListOpenWorkflowExecutionsRequest) bool {
+		return len(req.NextPageToken) == 0
+	})).Return(&workflowservice.ListOpenWorkflowExecutionsResponse{
+		Executions:    []*workflowpb.WorkflowExecutionInfo{openExecution(t, "upgrade-V1", workflowCodeVersion)},
+		NextPageToken: []byte("page2"),
+	}, nil).Once()
+	c.On("ListOpenWorkflow", mock.Anything, mock.MatchedBy(func(req *workflowservice.ListOpenWorkflowExecutionsRequest) bool {
+		return string(req.NextPageToken) == "page2"
+	})).Return(&workflowservice.ListOpenWorkflowExecutionsResponse{
+		Executions: []*workflowpb.WorkflowExecutionInfo{
+			openExecution(t, "upgrade-V2", workflowCodeVersion+1),
+			openExecution(t, "upgrade-V3", 0),
+		},
+	}, nil).Once()
+	err := checkWorkflowsDrained(context.Background(), c, "default")
+	if err == nil || !strings.Contains(err.Error(), "upgrade-V2") || !strings.Contains(err.Error(), "upgrade-V3(v0)") {
+		t.Fatalf("其他版本的流程应阻止启动，实际 %v", err)
+	}
+	c.AssertExpectations(t)
+}